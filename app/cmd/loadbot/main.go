@@ -0,0 +1,255 @@
+// Loadbot opens simulated players against a flappybird server, each polling
+// /get-screen at a fixed FPS and jumping to get through the pipes, then
+// reports latency percentiles, error rates and the FPS the players achieved.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	player_top_pattern = regexp.MustCompile(`\.player \{[^}]*?top:(-?[\d.]+)px`)
+	pipe_pattern       = regexp.MustCompile(`\.([a-z_-]+)_bottom \{\s*left:(-?\d+)px;\s*top:(-?\d+)px`)
+)
+
+const (
+	player_x      = 200
+	player_height = 34
+	pipe_width    = 64
+	jump_margin   = 70 // Jump once the bird is this close above the bottom pipe
+)
+
+type requestStats struct {
+	latencies []time.Duration
+	errors    int
+}
+
+type report struct {
+	requests map[string]*requestStats
+	frames   int
+	deaths   int
+	mut      sync.Mutex
+}
+
+func (r *report) record(name string, latency time.Duration, err error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	stats, ok := r.requests[name]
+	if !ok {
+		stats = &requestStats{}
+		r.requests[name] = stats
+	}
+
+	if err != nil {
+		stats.errors++
+		return
+	}
+	stats.latencies = append(stats.latencies, latency)
+}
+
+func (r *report) addFrame(dead bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.frames++
+	if dead {
+		r.deaths++
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func (r *report) print(players int, elapsed time.Duration) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	names := []string{}
+	for name := range r.requests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%-12s %8s %8s %10s %10s %10s %10s\n", "request", "count", "errors", "p50", "p90", "p99", "max")
+	for _, name := range names {
+		stats := r.requests[name]
+		sort.Slice(stats.latencies, func(i, j int) bool { return stats.latencies[i] < stats.latencies[j] })
+		total := len(stats.latencies) + stats.errors
+
+		fmt.Printf(
+			"%-12s %8d %7.2f%% %10s %10s %10s %10s\n",
+			name,
+			total,
+			100*float64(stats.errors)/float64(max(total, 1)),
+			percentile(stats.latencies, 0.5).Round(time.Microsecond),
+			percentile(stats.latencies, 0.9).Round(time.Microsecond),
+			percentile(stats.latencies, 0.99).Round(time.Microsecond),
+			percentile(stats.latencies, 1).Round(time.Microsecond),
+		)
+	}
+
+	fps := float64(r.frames) / elapsed.Seconds() / float64(players)
+	fmt.Printf("frames: %d deaths: %d achieved fps per player: %.1f\n", r.frames, r.deaths, fps)
+}
+
+type bot struct {
+	base_url string
+	client   *http.Client
+	report   *report
+}
+
+func (b *bot) request(name string, method string, path string) (string, http.Header, error) {
+	req, err := http.NewRequest(method, b.base_url+path, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("HX-Request", "true")
+
+	started := time.Now()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		b.report.record(name, 0, err)
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode >= 400 {
+		err = fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	b.report.record(name, time.Since(started), err)
+
+	return string(body), resp.Header, err
+}
+
+// Decides whether to jump from the bird's height and the gap of the next pipe
+func shouldJump(screen string) bool {
+	player_match := player_top_pattern.FindStringSubmatch(screen)
+	if player_match == nil {
+		return false
+	}
+	player_y, _ := strconv.ParseFloat(player_match[1], 64)
+
+	next_pipe_x := 1 << 30
+	next_pipe_bottom := 0
+	for _, pipe_match := range pipe_pattern.FindAllStringSubmatch(screen, -1) {
+		x, _ := strconv.Atoi(pipe_match[2])
+		bottom, _ := strconv.Atoi(pipe_match[3])
+		if x+pipe_width > player_x && x < next_pipe_x {
+			next_pipe_x = x
+			next_pipe_bottom = bottom
+		}
+	}
+
+	if next_pipe_bottom == 0 {
+		return player_y > 400
+	}
+
+	return player_y+player_height > float64(next_pipe_bottom-jump_margin)
+}
+
+// Plays until stop is closed, starting a new session whenever the bird dies
+func (b *bot) play(fps int, stop chan struct{}) {
+	frame_delay := time.Second / time.Duration(fps)
+
+	for {
+		_, _, err := b.request("index", "GET", "/")
+		if err != nil {
+			log.Printf("Could not start session: %v", err)
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		b.request("jump", "PUT", "/jump-player")
+
+		ticker := time.NewTicker(frame_delay)
+		dead := false
+
+		for !dead {
+			select {
+			case <-stop:
+				ticker.Stop()
+				return
+			case <-ticker.C:
+			}
+
+			screen, header, err := b.request("get-screen", "GET", "/get-screen")
+			if err != nil {
+				continue
+			}
+
+			dead = strings.Contains(screen, "You lose!") || header.Get("Hx-Trigger") == "get-dead-screen"
+			b.report.addFrame(dead)
+
+			if !dead && shouldJump(screen) {
+				b.request("jump", "PUT", "/jump-player")
+			}
+		}
+		ticker.Stop()
+	}
+}
+
+func main() {
+	base_url := flag.String("url", "http://localhost:3200", "server to load")
+	players := flag.Int("players", 10, "simulated players")
+	fps := flag.Int("fps", 30, "frames each player requests per second")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run")
+	ramp := flag.Duration("ramp", 5*time.Second, "time over which players are started")
+	flag.Parse()
+
+	if *players < 1 || *fps < 1 {
+		log.Fatal("players and fps must be at least 1")
+	}
+
+	results := &report{requests: map[string]*requestStats{}}
+	stop := make(chan struct{})
+	wait_group := sync.WaitGroup{}
+
+	started := time.Now()
+
+	for i := 0; i < *players; i++ {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		player_bot := &bot{
+			base_url: strings.TrimSuffix(*base_url, "/"),
+			client:   &http.Client{Jar: jar, Timeout: 10 * time.Second},
+			report:   results,
+		}
+
+		wait_group.Add(1)
+		go func() {
+			defer wait_group.Done()
+			player_bot.play(*fps, stop)
+		}()
+
+		time.Sleep(*ramp / time.Duration(*players))
+	}
+
+	time.Sleep(time.Until(started.Add(*duration)))
+	close(stop)
+	wait_group.Wait()
+
+	results.print(*players, time.Since(started))
+}
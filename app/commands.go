@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/config"
+	"github.com/deastl/flappybird-htmx/db"
+	"github.com/deastl/flappybird-htmx/game"
+	"github.com/deastl/flappybird-htmx/services"
+)
+
+// Runs a command line subcommand instead of the server
+func runCommand(args []string) {
+	var err error
+
+	switch args[0] {
+	case "migrate":
+		err = migrateCommand(args[1:])
+	case "verify-runs":
+		err = verifyRunsCommand(args[1:])
+	default:
+		log.Fatalf("Unknown command %s, available commands: migrate, verify-runs", args[0])
+	}
+
+	if err != nil {
+		log.Fatalf("%s failed : %v", args[0], err)
+	}
+}
+
+// Commands take their settings from the config file and environment, their
+// flags are their own
+func loadCommandConfig() (config.Config, db.Config, error) {
+	command_config, err := config.Load(nil)
+
+	if err != nil {
+		return command_config, db.Config{}, err
+	}
+
+	db_config, err := db.ParseDatabaseURL(command_config.Server.DatabaseURL)
+
+	return command_config, db_config, err
+}
+
+// Applies, rolls back or lists schema migrations
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status")
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	steps := flags.Int("steps", 1, "number of migrations to roll back")
+	flags.Parse(args[1:])
+
+	_, db_config, err := loadCommandConfig()
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Open(db_config)
+
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(conn, db_config.Dialect)
+		for _, migration := range applied {
+			log.Printf("Applied %04d_%s", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			log.Printf("Schema is up to date")
+		}
+		return err
+	case "down":
+		reverted, err := db.MigrateDown(conn, db_config.Dialect, *steps)
+		for _, migration := range reverted {
+			log.Printf("Rolled back %04d_%s", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			log.Printf("Nothing to roll back")
+		}
+		return err
+	case "status":
+		statuses, err := db.MigrationStatuses(conn, db_config.Dialect)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%-24s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+	default:
+		return errors.New("unknown migrate action " + args[0] + ", use up, down or status")
+	}
+}
+
+// Re-simulates every stored run with the gameplay settings it was recorded
+// with and reports those whose score doesn't match their replay, optionally
+// deleting them. Runs recorded before the settings were stored are checked
+// against the current settings instead
+func verifyRunsCommand(args []string) error {
+	flags := flag.NewFlagSet("verify-runs", flag.ExitOnError)
+	delete_invalid := flags.Bool("delete", false, "delete runs that fail verification")
+	delete_legacy := flags.Bool("delete_legacy", false, "with -delete, also delete failing runs recorded without their gameplay settings")
+	flags.Parse(args)
+
+	command_config, db_config, err := loadCommandConfig()
+
+	if err != nil {
+		return err
+	}
+
+	connection, err := db.NewConnection(db_config)
+
+	if err != nil {
+		return err
+	}
+
+	dbq := connection.Queries
+
+	ctx := context.Background()
+
+	run_replays, err := services.RunListWithReplays(ctx, dbq)
+
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+
+	for _, run_replay := range run_replays {
+		run := run_replay.Run
+		replay := run_replay.Replay
+
+		mode, err := game.ModeFromKey(run.Mode)
+
+		if err == nil {
+			_, err = game.VerifyRun(game.ReplayGameplay(mode, replay, command_config.Gameplay), replay.Seed, replay.JumpTicks, run.Score)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		invalid++
+		log.Printf("Run %s : %v", run.ID, err)
+
+		// Their settings are unknown, so they may only fail because the
+		// settings changed since
+		if replay.Gameplay == nil && !*delete_legacy {
+			continue
+		}
+
+		if *delete_invalid {
+			err = services.RunDelete(ctx, dbq, run.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("Verified %d runs, %d invalid", len(run_replays), invalid)
+
+	return nil
+}
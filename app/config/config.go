@@ -0,0 +1,309 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type Server struct {
+	Addr            string
+	DatabaseURL     string
+	TemplateDir     string
+	StaticDir       string
+	JWTSecret       string   // Signs new permanent tokens
+	JWTOldSecrets   []string // Still accepted when verifying, so secrets can be rotated
+	SessionTTL      time.Duration
+	TickWorkers     int
+	DailyOneAttempt bool // Only a player's first daily challenge run of the day is ranked
+	DebugOverlay    bool // Lets players turn on the collider and tick overlay, keep off in production
+	Dev             bool // Allows development defaults such as the fake jwt_secret
+}
+
+// Anyone can sign tokens with it, so it is only accepted in dev
+const defaultJWTSecret = "this_is_a_fake_secret"
+
+// Tuning of the simulation, every course generated and every replay
+// verified depends on these so changing them invalidates recorded runs
+type Gameplay struct {
+	TickDuration     time.Duration // Simulated time of one physics step
+	TargetFPS        int
+	Gravity          float64 // Added to the player's velocity every tick
+	JumpVelocity     float64 // Upward velocity a jump sets
+	FallScale        float64 // Pixels moved per tick per unit of velocity
+	FloorY           float64
+	PlayerX          float64
+	PlayerY          float64
+	PlayerWidth      int
+	PlayerHeight     int
+	PipeSpeed        int // Pixels the pipes and ground move per tick
+	PipeCount        int
+	PipeHorOffset    int  // Recycled pipes are placed twice this behind the furthest pipe
+	PipeStartingPos  int  // Extra space between the first pipes
+	PipeVariation    int  // Range of the random top pipe height
+	PipeGap          int  // Gap of the first pipes
+	PipeMinGap       int  // Smallest gap of recycled pipes
+	PipeGapVariation int  // Random extra gap of recycled pipes
+	Progressive      bool // Speeds up and narrows the gaps as points rise, set by the mode
+	SpeedUpPoints    int  // Points per extra pixel of pipe speed when progressive
+	MaxPipeSpeed     int
+	GapShrink        int // Pixels recycled gaps narrow by per point when progressive
+	GapFloor         int // Progression never narrows gaps below this
+}
+
+type Config struct {
+	Server   Server
+	Gameplay Gameplay
+}
+
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:        "0.0.0.0:3200",
+			DatabaseURL: "sqlite://./main.db",
+			TemplateDir: "templates",
+			StaticDir:   "local",
+			JWTSecret:   defaultJWTSecret,
+			SessionTTL:  2 * time.Minute,
+			TickWorkers: runtime.NumCPU(),
+		},
+		Gameplay: DefaultGameplay(),
+	}
+}
+
+func DefaultGameplay() Gameplay {
+	return Gameplay{
+		TickDuration:     30 * time.Millisecond,
+		TargetFPS:        30,
+		Gravity:          0.019,
+		JumpVelocity:     0.19,
+		FallScale:        20,
+		FloorY:           1200,
+		PlayerX:          200,
+		PlayerY:          300,
+		PlayerWidth:      50,
+		PlayerHeight:     32,
+		PipeSpeed:        15,
+		PipeCount:        4,
+		PipeHorOffset:    300,
+		PipeStartingPos:  500,
+		PipeVariation:    250,
+		PipeGap:          300,
+		PipeMinGap:       150,
+		PipeGapVariation: 100,
+		SpeedUpPoints:    5,
+		MaxPipeSpeed:     30,
+		GapShrink:        2,
+		GapFloor:         100,
+	}
+}
+
+type stringList struct {
+	values *[]string
+}
+
+func (l stringList) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l stringList) Set(value string) error {
+	*l.values = []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item != "" {
+			*l.values = append(*l.values, item)
+		}
+	}
+	return nil
+}
+
+// Every setting goes by the same name as a flag, an environment variable
+// and a key in the config file
+func (c *Config) flagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet("flappybird", flag.ContinueOnError)
+
+	server := &c.Server
+	flags.StringVar(&server.Addr, "addr", server.Addr, "address the http server listens on")
+	flags.StringVar(&server.DatabaseURL, "database_url", server.DatabaseURL, "sqlite://path, sqlite://:memory: or postgres://...")
+	flags.StringVar(&server.TemplateDir, "template_dir", server.TemplateDir, "directory the templates are read from")
+	flags.StringVar(&server.StaticDir, "static_dir", server.StaticDir, "directory served under /local/")
+	flags.StringVar(&server.JWTSecret, "jwt_secret", server.JWTSecret, "secret signing new user tokens")
+	flags.Var(stringList{&server.JWTOldSecrets}, "jwt_old_secrets", "comma separated secrets still accepted for old tokens")
+	flags.DurationVar(&server.SessionTTL, "session_ttl", server.SessionTTL, "idle time before a session is evicted")
+	flags.IntVar(&server.TickWorkers, "tick_workers", server.TickWorkers, "goroutines stepping sessions")
+	flags.BoolVar(&server.DailyOneAttempt, "daily_one_attempt", server.DailyOneAttempt, "rank only the first daily challenge run of each player")
+	flags.BoolVar(&server.DebugOverlay, "debug_overlay", server.DebugOverlay, "let players turn on the debug overlay with ?debug=1 or the D key")
+	flags.BoolVar(&server.Dev, "dev", server.Dev, "allow development defaults such as the default jwt_secret")
+
+	gameplay := &c.Gameplay
+	flags.DurationVar(&gameplay.TickDuration, "tick_duration", gameplay.TickDuration, "simulated time of one physics step")
+	flags.IntVar(&gameplay.TargetFPS, "target_fps", gameplay.TargetFPS, "frame rate new sessions start with")
+	flags.Float64Var(&gameplay.Gravity, "gravity", gameplay.Gravity, "velocity added every tick")
+	flags.Float64Var(&gameplay.JumpVelocity, "jump_velocity", gameplay.JumpVelocity, "upward velocity of a jump")
+	flags.Float64Var(&gameplay.FallScale, "fall_scale", gameplay.FallScale, "pixels moved per tick per unit of velocity")
+	flags.Float64Var(&gameplay.FloorY, "floor_y", gameplay.FloorY, "height the player dies at")
+	flags.Float64Var(&gameplay.PlayerX, "player_x", gameplay.PlayerX, "starting x of the player")
+	flags.Float64Var(&gameplay.PlayerY, "player_y", gameplay.PlayerY, "starting y of the player")
+	flags.IntVar(&gameplay.PlayerWidth, "player_width", gameplay.PlayerWidth, "width of the player's collider")
+	flags.IntVar(&gameplay.PlayerHeight, "player_height", gameplay.PlayerHeight, "height of the player's collider")
+	flags.IntVar(&gameplay.PipeSpeed, "pipe_speed", gameplay.PipeSpeed, "pixels the pipes move per tick")
+	flags.IntVar(&gameplay.PipeCount, "pipe_count", gameplay.PipeCount, "pipes on the course at once")
+	flags.IntVar(&gameplay.PipeHorOffset, "pipe_hor_offset", gameplay.PipeHorOffset, "half the space recycled pipes are placed behind the furthest pipe")
+	flags.IntVar(&gameplay.PipeStartingPos, "pipe_starting_pos", gameplay.PipeStartingPos, "extra space between the first pipes")
+	flags.IntVar(&gameplay.PipeVariation, "pipe_variation", gameplay.PipeVariation, "range of the random pipe height")
+	flags.IntVar(&gameplay.PipeGap, "pipe_gap", gameplay.PipeGap, "gap of the first pipes")
+	flags.IntVar(&gameplay.PipeMinGap, "pipe_min_gap", gameplay.PipeMinGap, "smallest gap of later pipes")
+	flags.IntVar(&gameplay.PipeGapVariation, "pipe_gap_variation", gameplay.PipeGapVariation, "random extra gap of later pipes")
+	flags.IntVar(&gameplay.SpeedUpPoints, "speed_up_points", gameplay.SpeedUpPoints, "points per extra pixel of pipe speed in progressive modes")
+	flags.IntVar(&gameplay.MaxPipeSpeed, "max_pipe_speed", gameplay.MaxPipeSpeed, "fastest pipe speed progressive modes reach")
+	flags.IntVar(&gameplay.GapShrink, "gap_shrink", gameplay.GapShrink, "pixels gaps narrow by per point in progressive modes")
+	flags.IntVar(&gameplay.GapFloor, "gap_floor", gameplay.GapFloor, "narrowest gap progressive modes reach")
+
+	return flags
+}
+
+// Builds the config from the defaults, then the config file, then the
+// environment and finally the flags in args, each overriding the last
+func Load(args []string) (Config, error) {
+	config := Default()
+	flags := config.flagSet()
+	config_file := flags.String("config_file", os.Getenv("config_file"), "file of name = value settings")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return config, err
+	}
+
+	from_flags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		from_flags[f.Name] = true
+	})
+
+	if *config_file != "" {
+		err = loadFile(flags, *config_file, from_flags)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	var env_errors []error
+	flags.VisitAll(func(f *flag.Flag) {
+		value := os.Getenv(f.Name)
+		if value == "" || from_flags[f.Name] || f.Name == "config_file" {
+			return
+		}
+		err := flags.Set(f.Name, value)
+		if err != nil {
+			env_errors = append(env_errors, fmt.Errorf("env %s: %w", f.Name, err))
+		}
+	})
+
+	if len(env_errors) > 0 {
+		return config, errors.Join(env_errors...)
+	}
+
+	return config, config.Validate()
+}
+
+// Reads name = value lines, blank lines and lines starting with # are skipped
+func loadFile(flags *flag.FlagSet, path string, skip map[string]bool) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return errors.New("Could not open config file: " + err.Error())
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line_number := 0
+
+	for scanner.Scan() {
+		line_number++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+
+		if !found || flags.Lookup(name) == nil || name == "config_file" {
+			return fmt.Errorf("%s:%d: unknown setting %q", path, line_number, line)
+		}
+
+		if skip[name] {
+			continue
+		}
+
+		err := flags.Set(name, strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, line_number, name, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (c Config) Validate() error {
+	var problems []error
+
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, errors.New(problem))
+		}
+	}
+
+	server := c.Server
+	check(server.Addr != "", "addr must be set")
+	check(server.DatabaseURL != "", "database_url must be set")
+	check(server.TemplateDir != "", "template_dir must be set")
+	check(server.JWTSecret != "", "jwt_secret must be set")
+	check(server.Dev || server.JWTSecret != defaultJWTSecret, "jwt_secret must be changed from the default outside dev")
+	check(server.SessionTTL > 0, "session_ttl must be positive")
+	check(server.TickWorkers > 0, "tick_workers must be at least 1")
+
+	problems = append(problems, c.Gameplay.Validate())
+
+	return errors.Join(problems...)
+}
+
+func (g Gameplay) Validate() error {
+	var problems []error
+
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, errors.New(problem))
+		}
+	}
+
+	check(g.TickDuration > 0, "tick_duration must be positive")
+	check(g.TargetFPS > 0 && g.TargetFPS <= 1000, "target_fps must be between 1 and 1000")
+	check(g.Gravity > 0, "gravity must be positive")
+	check(g.JumpVelocity > 0, "jump_velocity must be positive")
+	check(g.FallScale > 0, "fall_scale must be positive")
+	check(g.FloorY > g.PlayerY, "floor_y must be below player_y")
+	check(g.PlayerWidth > 0 && g.PlayerHeight > 0, "player_width and player_height must be positive")
+	check(g.PipeSpeed > 0, "pipe_speed must be positive")
+	check(g.PipeCount > 0, "pipe_count must be at least 1")
+	check(g.PipeHorOffset > 0, "pipe_hor_offset must be positive")
+	check(g.PipeStartingPos >= 0, "pipe_starting_pos can't be negative")
+	check(g.PipeVariation > 0, "pipe_variation must be positive")
+	check(g.PipeGap > 0, "pipe_gap must be positive")
+	check(g.PipeMinGap > 0, "pipe_min_gap must be positive")
+	check(g.PipeGapVariation > 0, "pipe_gap_variation must be positive")
+	check(g.SpeedUpPoints > 0, "speed_up_points must be positive")
+	check(g.MaxPipeSpeed >= g.PipeSpeed, "max_pipe_speed can't be below pipe_speed")
+	check(g.GapShrink >= 0, "gap_shrink can't be negative")
+	check(g.GapFloor > 0, "gap_floor must be positive")
+
+	return errors.Join(problems...)
+}
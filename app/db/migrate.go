@@ -0,0 +1,332 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Numbered migrations per dialect, NNNN_name.up.sql applies a change and
+// NNNN_name.down.sql reverts it, both dialects keep the same numbering
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migration_files embed.FS
+
+const migrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER NOT NULL PRIMARY KEY,
+  name TEXT NOT NULL,
+  applied_at TEXT NOT NULL
+)`
+
+// Key of the postgres advisory lock held while migrating, any number works
+// as long as every node uses the same
+const migrationLockKey = 4_716_230_901
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Reads the dialect's embedded migrations ordered by version
+func LoadMigrations(dialect Dialect) ([]Migration, error) {
+	dir := "migrations/" + string(dialect)
+	entries, err := fs.ReadDir(migration_files, dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	by_version := map[int]*Migration{}
+
+	for _, entry := range entries {
+		file_name := entry.Name()
+
+		direction := ""
+		base := ""
+		if strings.HasSuffix(file_name, ".up.sql") {
+			direction = "up"
+			base = strings.TrimSuffix(file_name, ".up.sql")
+		} else if strings.HasSuffix(file_name, ".down.sql") {
+			direction = "down"
+			base = strings.TrimSuffix(file_name, ".down.sql")
+		} else {
+			return nil, errors.New("Migration " + file_name + " must end in .up.sql or .down.sql")
+		}
+
+		version_str, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(version_str)
+
+		if !found || err != nil || version <= 0 {
+			return nil, errors.New("Migration " + file_name + " must be named NNNN_name")
+		}
+
+		contents, err := migration_files.ReadFile(dir + "/" + file_name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := by_version[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			by_version[version] = migration
+		}
+
+		if migration.Name != name {
+			return nil, fmt.Errorf("Migration %d has two names, %s and %s", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range by_version {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("Migration %d is missing its up file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	_, err := db.Exec(migrationsTable)
+
+	if err != nil {
+		return nil, errors.New("Could not create schema_migrations: " + err.Error())
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+
+	for rows.Next() {
+		var version int
+		var applied_at string
+
+		err := rows.Scan(&version, &applied_at)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version], _ = time.Parse(time.RFC3339, applied_at)
+	}
+
+	return applied, rows.Err()
+}
+
+// Runs a migration's sql and records it in schema_migrations within one
+// transaction, so a failing migration leaves nothing behind
+func applyMigration(db *sql.DB, dialect Dialect, migration Migration, up bool) error {
+	tx, err := db.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		_, err = tx.Exec(migration.Up)
+		if err == nil {
+			insert := "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"
+			if dialect == Postgres {
+				insert = "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)"
+			}
+			_, err = tx.Exec(
+				insert,
+				migration.Version, migration.Name, time.Now().UTC().Format(time.RFC3339),
+			)
+		}
+	} else {
+		_, err = tx.Exec(migration.Down)
+		if err == nil {
+			remove := "DELETE FROM schema_migrations WHERE version = ?"
+			if dialect == Postgres {
+				remove = "DELETE FROM schema_migrations WHERE version = $1"
+			}
+			_, err = tx.Exec(remove, migration.Version)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("Migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit()
+}
+
+// Keeps nodes sharing a postgres database from migrating it at the same
+// time, the others wait here and then find the migrations applied. Call the
+// returned func to let them go. Sqlite databases belong to a single node
+func lockMigrations(db *sql.DB, dialect Dialect) (func(), error) {
+	if dialect != Postgres {
+		return func() {}, nil
+	}
+
+	ctx := context.Background()
+
+	// Advisory locks belong to a connection, so unlock on the same one
+	conn, err := db.Conn(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey)
+
+	if err != nil {
+		conn.Close()
+		return nil, errors.New("Could not lock migrations: " + err.Error())
+	}
+
+	return func() {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		if err != nil {
+			// Closing the connection releases the lock as well
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// Applies every pending migration in order and returns the ones it applied
+func MigrateUp(db *sql.DB, dialect Dialect) ([]Migration, error) {
+	migrations, err := LoadMigrations(dialect)
+
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockMigrations(db, dialect)
+
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Read after locking, another node may have migrated while this one waited
+	applied, err := appliedMigrations(db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := applyMigration(db, dialect, migration, true)
+		if err != nil {
+			return done, err
+		}
+
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Reverts the latest applied migrations, newest first
+func MigrateDown(db *sql.DB, dialect Dialect, steps int) ([]Migration, error) {
+	migrations, err := LoadMigrations(dialect)
+
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockMigrations(db, dialect)
+
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := migrations[i]
+
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if migration.Down == "" {
+			return done, fmt.Errorf("Migration %04d_%s can't be rolled back", migration.Version, migration.Name)
+		}
+
+		err := applyMigration(db, dialect, migration, false)
+		if err != nil {
+			return done, err
+		}
+
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+func MigrationStatuses(db *sql.DB, dialect Dialect) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations(dialect)
+
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+
+	for _, migration := range migrations {
+		applied_at, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: applied_at,
+		})
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS users (
+  id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  last_score BIGINT NOT NULL,
+  top_score BIGINT NOT NULL,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS runs (
+  id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  session_id TEXT NOT NULL,
+  score BIGINT NOT NULL,
+  duration_ms BIGINT NOT NULL,
+  frames BIGINT NOT NULL,
+  created_at TEXT NOT NULL
+);
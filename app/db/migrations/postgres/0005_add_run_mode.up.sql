@@ -0,0 +1 @@
+ALTER TABLE runs ADD COLUMN mode TEXT NOT NULL DEFAULT 'normal';
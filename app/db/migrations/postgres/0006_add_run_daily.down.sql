@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS runs_daily;
+ALTER TABLE runs DROP COLUMN counted;
+ALTER TABLE runs DROP COLUMN daily;
@@ -0,0 +1 @@
+ALTER TABLE replays ADD COLUMN gameplay TEXT NOT NULL DEFAULT '';
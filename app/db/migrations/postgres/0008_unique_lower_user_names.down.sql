@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_name_lower;
+CREATE UNIQUE INDEX IF NOT EXISTS users_name ON users (name) WHERE name != '';
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS replays (
+  run_id TEXT NOT NULL,
+  seed INTEGER NOT NULL,
+  jump_ticks TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE runs ADD COLUMN daily TEXT NOT NULL DEFAULT '';
+ALTER TABLE runs ADD COLUMN counted INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS runs_daily ON runs (daily);
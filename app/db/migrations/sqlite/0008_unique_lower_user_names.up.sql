@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_name;
+CREATE UNIQUE INDEX IF NOT EXISTS users_name_lower ON users (lower(name)) WHERE name != '';
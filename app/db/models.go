@@ -6,6 +6,27 @@ package db
 
 import ()
 
+type Replay struct {
+	RunID     string
+	Seed      int64
+	JumpTicks string
+	CreatedAt string
+	Gameplay  string
+}
+
+type Run struct {
+	ID         string
+	UserID     string
+	SessionID  string
+	Score      int64
+	DurationMs int64
+	Frames     int64
+	CreatedAt  string
+	Mode       string
+	Daily      string
+	Counted    int64
+}
+
 type User struct {
 	ID        string
 	Name      string
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	_ "embed"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// sqlc generates the Go code from queries.sql, which is written for sqlite.
+// queries.postgres.sql holds the same queries for postgres, in the same
+// order and with the same columns, and postgres connections run its
+// statement of the same name in place of the generated one
+//
+//go:embed queries.postgres.sql
+var postgres_queries string
+
+var postgres_statements = parseQueries(postgres_queries)
+
+// Reads the statements of a query file by the name in their -- name: line
+func parseQueries(source string) map[string]string {
+	statements := map[string]string{}
+
+	for _, block := range strings.Split(source, "-- name: ")[1:] {
+		header, statement, _ := strings.Cut(block, "\n")
+		name := strings.Fields(header)[0]
+		statements[name] = strings.TrimSuffix(strings.TrimSpace(statement), ";")
+	}
+
+	return statements
+}
+
+// The postgres statement of a generated query. Other sql, like the
+// migrations, is written for the dialect it runs on and passes unchanged
+func postgresStatement(query string) (string, error) {
+	if !strings.HasPrefix(query, "-- name: ") {
+		return query, nil
+	}
+
+	header, _, _ := strings.Cut(strings.TrimPrefix(query, "-- name: "), "\n")
+	name := strings.Fields(header)[0]
+
+	statement, ok := postgres_statements[name]
+	if !ok {
+		return "", errors.New("No postgres statement for query " + name + " in queries.postgres.sql")
+	}
+
+	return statement, nil
+}
+
+// What lib/pq connections implement, postgresConn passes all of it on
+type pqConn interface {
+	driver.Conn
+	driver.ConnPrepareContext
+	driver.ConnBeginTx
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+	driver.NamedValueChecker
+}
+
+// A lib/pq connection running the postgres statements of generated
+// queries. It sits below database/sql, so queries on transactions, like
+// the ones of Queries.WithTx, get them as well
+type postgresConn struct {
+	pqConn
+}
+
+func (c postgresConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c postgresConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	statement, err := postgresStatement(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.pqConn.PrepareContext(ctx, statement)
+}
+
+func (c postgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	statement, err := postgresStatement(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.pqConn.ExecContext(ctx, statement, args)
+}
+
+func (c postgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	statement, err := postgresStatement(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.pqConn.QueryContext(ctx, statement, args)
+}
+
+type postgresConnector struct {
+	connector *pq.Connector
+}
+
+func (c postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pq_conn, ok := conn.(pqConn)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("The lib/pq connection lacks a driver interface postgresConn passes on")
+	}
+
+	return postgresConn{pq_conn}, nil
+}
+
+func (c postgresConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
@@ -0,0 +1,56 @@
+-- name: CreateUser :exec
+INSERT INTO users (id,name, created_at, updated_at, last_score, top_score) VALUES ( $1,$2,$3,$4,$5,$6);
+
+-- name: GetUserByName :one
+SELECT id, name, last_score, top_score, created_at, updated_at FROM users WHERE lower(name) = lower($1);
+
+-- name: GetUserByID :one
+SELECT id, name, last_score, top_score, created_at, updated_at FROM users WHERE id = $1;
+
+-- name: UpdateUserScores :exec
+UPDATE users SET last_score = $1, top_score = $2, updated_at = $3 WHERE id = $4;
+
+-- name: CreateRun :exec
+INSERT INTO runs (id, user_id, session_id, score, duration_ms, frames, created_at, mode, daily, counted) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10);
+
+-- name: ListTopRunsSince :many
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, COALESCE(users.name, '') AS user_name FROM runs LEFT JOIN users ON users.id = runs.user_id WHERE runs.created_at >= $1 AND runs.mode = $2 ORDER BY runs.score DESC, runs.created_at ASC LIMIT $3;
+
+-- name: CreateReplay :exec
+INSERT INTO replays (run_id, seed, jump_ticks, created_at, gameplay) VALUES ($1,$2,$3,$4,$5);
+
+-- name: GetReplayByRunID :one
+SELECT run_id, seed, jump_ticks, created_at, gameplay FROM replays WHERE run_id = $1;
+
+-- name: ListRunReplays :many
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id ORDER BY runs.created_at;
+
+-- name: DeleteRun :exec
+DELETE FROM runs WHERE id = $1;
+
+-- name: DeleteReplay :exec
+DELETE FROM replays WHERE run_id = $1;
+
+-- name: GetBestRunReplayByUser :one
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id WHERE runs.user_id = $1 AND runs.mode = $2 ORDER BY runs.score DESC, runs.created_at ASC LIMIT 1;
+
+-- name: GetBestRunReplayBySession :one
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id WHERE runs.session_id = $1 AND runs.mode = $2 ORDER BY runs.score DESC, runs.created_at ASC LIMIT 1;
+
+-- name: UpdateUserName :exec
+UPDATE users SET name = $1, updated_at = $2 WHERE id = $3;
+
+-- name: CountRunsByUser :one
+SELECT COUNT(*) FROM runs WHERE user_id = $1;
+
+-- name: GetRunReplay :one
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id WHERE runs.id = $1;
+
+-- name: CountDailyRunsByUser :one
+SELECT COUNT(*) FROM runs WHERE daily = $1 AND user_id = $2;
+
+-- name: CountDailyRunsBySession :one
+SELECT COUNT(*) FROM runs WHERE daily = $1 AND session_id = $2;
+
+-- name: ListTopDailyRuns :many
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, COALESCE(users.name, '') AS user_name FROM runs LEFT JOIN users ON users.id = runs.user_id WHERE runs.daily = $1 AND runs.counted = 1 ORDER BY runs.score DESC, runs.created_at ASC LIMIT $2;
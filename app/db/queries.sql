@@ -2,4 +2,55 @@
 INSERT INTO users (id,name, created_at, updated_at, last_score, top_score) VALUES ( ?,?,?,?,?,?);
 
 -- name: GetUserByName :one
-SELECT * FROM users WHERE name = ?;
+SELECT id, name, last_score, top_score, created_at, updated_at FROM users WHERE lower(name) = lower(?);
+
+-- name: GetUserByID :one
+SELECT id, name, last_score, top_score, created_at, updated_at FROM users WHERE id = ?;
+
+-- name: UpdateUserScores :exec
+UPDATE users SET last_score = ?, top_score = ?, updated_at = ? WHERE id = ?;
+
+-- name: CreateRun :exec
+INSERT INTO runs (id, user_id, session_id, score, duration_ms, frames, created_at, mode, daily, counted) VALUES (?,?,?,?,?,?,?,?,?,?);
+
+-- name: ListTopRunsSince :many
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, COALESCE(users.name, '') AS user_name FROM runs LEFT JOIN users ON users.id = runs.user_id WHERE runs.created_at >= ? AND runs.mode = ? ORDER BY runs.score DESC, runs.created_at ASC LIMIT ?;
+
+-- name: CreateReplay :exec
+INSERT INTO replays (run_id, seed, jump_ticks, created_at, gameplay) VALUES (?,?,?,?,?);
+
+-- name: GetReplayByRunID :one
+SELECT run_id, seed, jump_ticks, created_at, gameplay FROM replays WHERE run_id = ?;
+
+-- name: ListRunReplays :many
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id ORDER BY runs.created_at;
+
+-- name: DeleteRun :exec
+DELETE FROM runs WHERE id = ?;
+
+-- name: DeleteReplay :exec
+DELETE FROM replays WHERE run_id = ?;
+
+-- name: GetBestRunReplayByUser :one
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id WHERE runs.user_id = ? AND runs.mode = ? ORDER BY runs.score DESC, runs.created_at ASC LIMIT 1;
+
+-- name: GetBestRunReplayBySession :one
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id WHERE runs.session_id = ? AND runs.mode = ? ORDER BY runs.score DESC, runs.created_at ASC LIMIT 1;
+
+-- name: UpdateUserName :exec
+UPDATE users SET name = ?, updated_at = ? WHERE id = ?;
+
+-- name: CountRunsByUser :one
+SELECT COUNT(*) FROM runs WHERE user_id = ?;
+
+-- name: GetRunReplay :one
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id WHERE runs.id = ?;
+
+-- name: CountDailyRunsByUser :one
+SELECT COUNT(*) FROM runs WHERE daily = ? AND user_id = ?;
+
+-- name: CountDailyRunsBySession :one
+SELECT COUNT(*) FROM runs WHERE daily = ? AND session_id = ?;
+
+-- name: ListTopDailyRuns :many
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, COALESCE(users.name, '') AS user_name FROM runs LEFT JOIN users ON users.id = runs.user_id WHERE runs.daily = ? AND runs.counted = 1 ORDER BY runs.score DESC, runs.created_at ASC LIMIT ?;
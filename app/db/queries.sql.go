@@ -9,6 +9,105 @@ import (
 	"context"
 )
 
+const countDailyRunsBySession = `-- name: CountDailyRunsBySession :one
+SELECT COUNT(*) FROM runs WHERE daily = ? AND session_id = ?
+`
+
+type CountDailyRunsBySessionParams struct {
+	Daily     string
+	SessionID string
+}
+
+func (q *Queries) CountDailyRunsBySession(ctx context.Context, arg CountDailyRunsBySessionParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countDailyRunsBySession, arg.Daily, arg.SessionID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countDailyRunsByUser = `-- name: CountDailyRunsByUser :one
+SELECT COUNT(*) FROM runs WHERE daily = ? AND user_id = ?
+`
+
+type CountDailyRunsByUserParams struct {
+	Daily  string
+	UserID string
+}
+
+func (q *Queries) CountDailyRunsByUser(ctx context.Context, arg CountDailyRunsByUserParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countDailyRunsByUser, arg.Daily, arg.UserID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countRunsByUser = `-- name: CountRunsByUser :one
+SELECT COUNT(*) FROM runs WHERE user_id = ?
+`
+
+func (q *Queries) CountRunsByUser(ctx context.Context, userID string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countRunsByUser, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createReplay = `-- name: CreateReplay :exec
+INSERT INTO replays (run_id, seed, jump_ticks, created_at, gameplay) VALUES (?,?,?,?,?)
+`
+
+type CreateReplayParams struct {
+	RunID     string
+	Seed      int64
+	JumpTicks string
+	CreatedAt string
+	Gameplay  string
+}
+
+func (q *Queries) CreateReplay(ctx context.Context, arg CreateReplayParams) error {
+	_, err := q.db.ExecContext(ctx, createReplay,
+		arg.RunID,
+		arg.Seed,
+		arg.JumpTicks,
+		arg.CreatedAt,
+		arg.Gameplay,
+	)
+	return err
+}
+
+const createRun = `-- name: CreateRun :exec
+INSERT INTO runs (id, user_id, session_id, score, duration_ms, frames, created_at, mode, daily, counted) VALUES (?,?,?,?,?,?,?,?,?,?)
+`
+
+type CreateRunParams struct {
+	ID         string
+	UserID     string
+	SessionID  string
+	Score      int64
+	DurationMs int64
+	Frames     int64
+	CreatedAt  string
+	Mode       string
+	Daily      string
+	Counted    int64
+}
+
+func (q *Queries) CreateRun(ctx context.Context, arg CreateRunParams) error {
+	_, err := q.db.ExecContext(ctx, createRun,
+		arg.ID,
+		arg.UserID,
+		arg.SessionID,
+		arg.Score,
+		arg.DurationMs,
+		arg.Frames,
+		arg.CreatedAt,
+		arg.Mode,
+		arg.Daily,
+		arg.Counted,
+	)
+	return err
+}
+
 const createUser = `-- name: CreateUser :exec
 INSERT INTO users (id,name, created_at, updated_at, last_score, top_score) VALUES ( ?,?,?,?,?,?)
 `
@@ -34,8 +133,182 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) error {
 	return err
 }
 
+const deleteReplay = `-- name: DeleteReplay :exec
+DELETE FROM replays WHERE run_id = ?
+`
+
+func (q *Queries) DeleteReplay(ctx context.Context, runID string) error {
+	_, err := q.db.ExecContext(ctx, deleteReplay, runID)
+	return err
+}
+
+const deleteRun = `-- name: DeleteRun :exec
+DELETE FROM runs WHERE id = ?
+`
+
+func (q *Queries) DeleteRun(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, deleteRun, id)
+	return err
+}
+
+const getBestRunReplayBySession = `-- name: GetBestRunReplayBySession :one
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id WHERE runs.session_id = ? AND runs.mode = ? ORDER BY runs.score DESC, runs.created_at ASC LIMIT 1
+`
+
+type GetBestRunReplayBySessionParams struct {
+	SessionID string
+	Mode      string
+}
+
+type GetBestRunReplayBySessionRow struct {
+	ID         string
+	UserID     string
+	SessionID  string
+	Score      int64
+	DurationMs int64
+	Frames     int64
+	CreatedAt  string
+	Mode       string
+	Seed       int64
+	JumpTicks  string
+	Gameplay   string
+}
+
+func (q *Queries) GetBestRunReplayBySession(ctx context.Context, arg GetBestRunReplayBySessionParams) (GetBestRunReplayBySessionRow, error) {
+	row := q.db.QueryRowContext(ctx, getBestRunReplayBySession, arg.SessionID, arg.Mode)
+	var i GetBestRunReplayBySessionRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.SessionID,
+		&i.Score,
+		&i.DurationMs,
+		&i.Frames,
+		&i.CreatedAt,
+		&i.Mode,
+		&i.Seed,
+		&i.JumpTicks,
+		&i.Gameplay,
+	)
+	return i, err
+}
+
+const getBestRunReplayByUser = `-- name: GetBestRunReplayByUser :one
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id WHERE runs.user_id = ? AND runs.mode = ? ORDER BY runs.score DESC, runs.created_at ASC LIMIT 1
+`
+
+type GetBestRunReplayByUserParams struct {
+	UserID string
+	Mode   string
+}
+
+type GetBestRunReplayByUserRow struct {
+	ID         string
+	UserID     string
+	SessionID  string
+	Score      int64
+	DurationMs int64
+	Frames     int64
+	CreatedAt  string
+	Mode       string
+	Seed       int64
+	JumpTicks  string
+	Gameplay   string
+}
+
+func (q *Queries) GetBestRunReplayByUser(ctx context.Context, arg GetBestRunReplayByUserParams) (GetBestRunReplayByUserRow, error) {
+	row := q.db.QueryRowContext(ctx, getBestRunReplayByUser, arg.UserID, arg.Mode)
+	var i GetBestRunReplayByUserRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.SessionID,
+		&i.Score,
+		&i.DurationMs,
+		&i.Frames,
+		&i.CreatedAt,
+		&i.Mode,
+		&i.Seed,
+		&i.JumpTicks,
+		&i.Gameplay,
+	)
+	return i, err
+}
+
+const getReplayByRunID = `-- name: GetReplayByRunID :one
+SELECT run_id, seed, jump_ticks, created_at, gameplay FROM replays WHERE run_id = ?
+`
+
+func (q *Queries) GetReplayByRunID(ctx context.Context, runID string) (Replay, error) {
+	row := q.db.QueryRowContext(ctx, getReplayByRunID, runID)
+	var i Replay
+	err := row.Scan(
+		&i.RunID,
+		&i.Seed,
+		&i.JumpTicks,
+		&i.CreatedAt,
+		&i.Gameplay,
+	)
+	return i, err
+}
+
+const getRunReplay = `-- name: GetRunReplay :one
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id WHERE runs.id = ?
+`
+
+type GetRunReplayRow struct {
+	ID         string
+	UserID     string
+	SessionID  string
+	Score      int64
+	DurationMs int64
+	Frames     int64
+	CreatedAt  string
+	Mode       string
+	Seed       int64
+	JumpTicks  string
+	Gameplay   string
+}
+
+func (q *Queries) GetRunReplay(ctx context.Context, id string) (GetRunReplayRow, error) {
+	row := q.db.QueryRowContext(ctx, getRunReplay, id)
+	var i GetRunReplayRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.SessionID,
+		&i.Score,
+		&i.DurationMs,
+		&i.Frames,
+		&i.CreatedAt,
+		&i.Mode,
+		&i.Seed,
+		&i.JumpTicks,
+		&i.Gameplay,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, name, last_score, top_score, created_at, updated_at FROM users WHERE id = ?
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.LastScore,
+		&i.TopScore,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getUserByName = `-- name: GetUserByName :one
-SELECT id, name, last_score, top_score, created_at, updated_at FROM users WHERE name = ?
+SELECT id, name, last_score, top_score, created_at, updated_at FROM users WHERE lower(name) = lower(?)
 `
 
 func (q *Queries) GetUserByName(ctx context.Context, name string) (User, error) {
@@ -51,3 +324,201 @@ func (q *Queries) GetUserByName(ctx context.Context, name string) (User, error)
 	)
 	return i, err
 }
+
+const listRunReplays = `-- name: ListRunReplays :many
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, replays.seed, replays.jump_ticks, replays.gameplay FROM runs JOIN replays ON replays.run_id = runs.id ORDER BY runs.created_at
+`
+
+type ListRunReplaysRow struct {
+	ID         string
+	UserID     string
+	SessionID  string
+	Score      int64
+	DurationMs int64
+	Frames     int64
+	CreatedAt  string
+	Mode       string
+	Seed       int64
+	JumpTicks  string
+	Gameplay   string
+}
+
+func (q *Queries) ListRunReplays(ctx context.Context) ([]ListRunReplaysRow, error) {
+	rows, err := q.db.QueryContext(ctx, listRunReplays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRunReplaysRow
+	for rows.Next() {
+		var i ListRunReplaysRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.SessionID,
+			&i.Score,
+			&i.DurationMs,
+			&i.Frames,
+			&i.CreatedAt,
+			&i.Mode,
+			&i.Seed,
+			&i.JumpTicks,
+			&i.Gameplay,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTopDailyRuns = `-- name: ListTopDailyRuns :many
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, COALESCE(users.name, '') AS user_name FROM runs LEFT JOIN users ON users.id = runs.user_id WHERE runs.daily = ? AND runs.counted = 1 ORDER BY runs.score DESC, runs.created_at ASC LIMIT ?
+`
+
+type ListTopDailyRunsParams struct {
+	Daily string
+	Limit int64
+}
+
+type ListTopDailyRunsRow struct {
+	ID         string
+	UserID     string
+	SessionID  string
+	Score      int64
+	DurationMs int64
+	Frames     int64
+	CreatedAt  string
+	Mode       string
+	UserName   string
+}
+
+func (q *Queries) ListTopDailyRuns(ctx context.Context, arg ListTopDailyRunsParams) ([]ListTopDailyRunsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTopDailyRuns, arg.Daily, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTopDailyRunsRow
+	for rows.Next() {
+		var i ListTopDailyRunsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.SessionID,
+			&i.Score,
+			&i.DurationMs,
+			&i.Frames,
+			&i.CreatedAt,
+			&i.Mode,
+			&i.UserName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTopRunsSince = `-- name: ListTopRunsSince :many
+SELECT runs.id, runs.user_id, runs.session_id, runs.score, runs.duration_ms, runs.frames, runs.created_at, runs.mode, COALESCE(users.name, '') AS user_name FROM runs LEFT JOIN users ON users.id = runs.user_id WHERE runs.created_at >= ? AND runs.mode = ? ORDER BY runs.score DESC, runs.created_at ASC LIMIT ?
+`
+
+type ListTopRunsSinceParams struct {
+	CreatedAt string
+	Mode      string
+	Limit     int64
+}
+
+type ListTopRunsSinceRow struct {
+	ID         string
+	UserID     string
+	SessionID  string
+	Score      int64
+	DurationMs int64
+	Frames     int64
+	CreatedAt  string
+	Mode       string
+	UserName   string
+}
+
+func (q *Queries) ListTopRunsSince(ctx context.Context, arg ListTopRunsSinceParams) ([]ListTopRunsSinceRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTopRunsSince, arg.CreatedAt, arg.Mode, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTopRunsSinceRow
+	for rows.Next() {
+		var i ListTopRunsSinceRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.SessionID,
+			&i.Score,
+			&i.DurationMs,
+			&i.Frames,
+			&i.CreatedAt,
+			&i.Mode,
+			&i.UserName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateUserName = `-- name: UpdateUserName :exec
+UPDATE users SET name = ?, updated_at = ? WHERE id = ?
+`
+
+type UpdateUserNameParams struct {
+	Name      string
+	UpdatedAt string
+	ID        string
+}
+
+func (q *Queries) UpdateUserName(ctx context.Context, arg UpdateUserNameParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserName, arg.Name, arg.UpdatedAt, arg.ID)
+	return err
+}
+
+const updateUserScores = `-- name: UpdateUserScores :exec
+UPDATE users SET last_score = ?, top_score = ?, updated_at = ? WHERE id = ?
+`
+
+type UpdateUserScoresParams struct {
+	LastScore int64
+	TopScore  int64
+	UpdatedAt string
+	ID        string
+}
+
+func (q *Queries) UpdateUserScores(ctx context.Context, arg UpdateUserScoresParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserScores,
+		arg.LastScore,
+		arg.TopScore,
+		arg.UpdatedAt,
+		arg.ID,
+	)
+	return err
+}
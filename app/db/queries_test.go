@@ -0,0 +1,338 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/utils"
+)
+
+// Databases the queries are checked against, postgres only runs when
+// FLAPPYBIRD_TEST_POSTGRES_URL names a database the test may write to
+func testDatabases(t *testing.T) map[string]Config {
+	databases := map[string]Config{
+		"sqlite": {Dialect: SQLite, DSN: ":memory:"},
+	}
+
+	if url := os.Getenv("FLAPPYBIRD_TEST_POSTGRES_URL"); url != "" {
+		databases["postgres"] = Config{Dialect: Postgres, DSN: url}
+	} else {
+		t.Log("FLAPPYBIRD_TEST_POSTGRES_URL not set, skipping postgres")
+	}
+
+	return databases
+}
+
+func openTestDatabase(t *testing.T, config Config) *sql.DB {
+	db, err := Open(config)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = MigrateUp(db, config.Dialect)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return db
+}
+
+// The postgres statements must stay the generated queries with postgres
+// placeholders, the generated code scans their columns in sqlite's order
+func TestPostgresQueries(t *testing.T) {
+	source, err := os.ReadFile("queries.sql")
+	if err != nil {
+		t.Fatalf("read queries.sql: %v", err)
+	}
+	sqlite_statements := parseQueries(string(source))
+
+	if len(sqlite_statements) != len(postgres_statements) {
+		t.Errorf("%d sqlite queries but %d postgres ones", len(sqlite_statements), len(postgres_statements))
+	}
+
+	placeholders := regexp.MustCompile(`\$[0-9]+`)
+
+	for name, statement := range sqlite_statements {
+		postgres, ok := postgres_statements[name]
+		if !ok {
+			t.Errorf("%s has no postgres statement", name)
+			continue
+		}
+		if got := placeholders.ReplaceAllString(postgres, "?"); got != statement {
+			t.Errorf("%s differs between the dialects:\n%s\n%s", name, statement, postgres)
+		}
+	}
+
+	statement, err := postgresStatement(createRun)
+	if err != nil || !strings.Contains(statement, "$10") {
+		t.Errorf("postgresStatement(createRun) = %q, %v", statement, err)
+	}
+
+	raw := "SELECT pg_advisory_lock($1)"
+	if statement, _ := postgresStatement(raw); statement != raw {
+		t.Errorf("postgresStatement changed %q to %q", raw, statement)
+	}
+
+	_, err = postgresStatement("-- name: Missing :one\nSELECT 1")
+	if err == nil {
+		t.Error("a query without a postgres statement passed")
+	}
+}
+
+// Runs every generated query through the dialect's connection
+func TestQueries(t *testing.T) {
+	for name, config := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			db := openTestDatabase(t, config)
+			q := New(db)
+
+			// Rows are keyed by fresh ids, so a shared postgres database
+			// doesn't have to be empty
+			user_id := utils.GenID(16)
+			session_id := utils.GenID(16)
+			run_id := utils.GenID(16)
+			name := utils.GenID(12)
+			day := "2001-" + utils.GenID(5)
+			now := time.Now().UTC().Format(time.RFC3339)
+
+			check := func(step string, err error) {
+				t.Helper()
+				if err != nil {
+					t.Fatalf("%s: %v", step, err)
+				}
+			}
+
+			check("CreateUser", q.CreateUser(ctx, CreateUserParams{ID: user_id, Name: name, CreatedAt: now, UpdatedAt: now}))
+			check("UpdateUserScores", q.UpdateUserScores(ctx, UpdateUserScoresParams{LastScore: 3, TopScore: 7, UpdatedAt: now, ID: user_id}))
+
+			user, err := q.GetUserByName(ctx, name)
+			check("GetUserByName", err)
+			if user.ID != user_id || user.TopScore != 7 {
+				t.Errorf("GetUserByName = %+v", user)
+			}
+
+			// Names differing only in case belong to the same user
+			user, err = q.GetUserByName(ctx, strings.ToUpper(name))
+			check("GetUserByName upper case", err)
+			if user.ID != user_id {
+				t.Errorf("GetUserByName upper case = %+v", user)
+			}
+			err = q.CreateUser(ctx, CreateUserParams{ID: utils.GenID(16), Name: strings.ToUpper(name), CreatedAt: now, UpdatedAt: now})
+			if !IsUniqueViolation(err) {
+				t.Errorf("created a second user called %q: %v", strings.ToUpper(name), err)
+			}
+
+			check("UpdateUserName", q.UpdateUserName(ctx, UpdateUserNameParams{Name: name + "x", UpdatedAt: now, ID: user_id}))
+			user, err = q.GetUserByID(ctx, user_id)
+			check("GetUserByID", err)
+			if user.Name != name+"x" {
+				t.Errorf("renamed user is called %q", user.Name)
+			}
+
+			check("CreateRun", q.CreateRun(ctx, CreateRunParams{
+				ID: run_id, UserID: user_id, SessionID: session_id, Score: 12, DurationMs: 4000,
+				Frames: 120, CreatedAt: now, Mode: "normal", Daily: day, Counted: 1,
+			}))
+			check("CreateReplay", q.CreateReplay(ctx, CreateReplayParams{RunID: run_id, Seed: 42, JumpTicks: "[1,5]", CreatedAt: now, Gameplay: `{"Gravity":1}`}))
+
+			count, err := q.CountRunsByUser(ctx, user_id)
+			check("CountRunsByUser", err)
+			if count != 1 {
+				t.Errorf("CountRunsByUser = %d, want 1", count)
+			}
+
+			count, err = q.CountDailyRunsByUser(ctx, CountDailyRunsByUserParams{Daily: day, UserID: user_id})
+			check("CountDailyRunsByUser", err)
+			if count != 1 {
+				t.Errorf("CountDailyRunsByUser = %d, want 1", count)
+			}
+
+			count, err = q.CountDailyRunsBySession(ctx, CountDailyRunsBySessionParams{Daily: day, SessionID: session_id})
+			check("CountDailyRunsBySession", err)
+			if count != 1 {
+				t.Errorf("CountDailyRunsBySession = %d, want 1", count)
+			}
+
+			top, err := q.ListTopRunsSince(ctx, ListTopRunsSinceParams{CreatedAt: now, Mode: "normal", Limit: 1000})
+			check("ListTopRunsSince", err)
+			found := false
+			for _, row := range top {
+				found = found || (row.ID == run_id && row.UserName == name+"x")
+			}
+			if !found {
+				t.Errorf("ListTopRunsSince misses the run: %+v", top)
+			}
+
+			daily, err := q.ListTopDailyRuns(ctx, ListTopDailyRunsParams{Daily: day, Limit: 10})
+			check("ListTopDailyRuns", err)
+			if len(daily) != 1 || daily[0].ID != run_id {
+				t.Errorf("ListTopDailyRuns = %+v", daily)
+			}
+
+			replay, err := q.GetReplayByRunID(ctx, run_id)
+			check("GetReplayByRunID", err)
+			if replay.Seed != 42 || replay.JumpTicks != "[1,5]" || replay.Gameplay != `{"Gravity":1}` {
+				t.Errorf("GetReplayByRunID = %+v", replay)
+			}
+
+			by_user, err := q.GetBestRunReplayByUser(ctx, GetBestRunReplayByUserParams{UserID: user_id, Mode: "normal"})
+			check("GetBestRunReplayByUser", err)
+			if by_user.ID != run_id {
+				t.Errorf("GetBestRunReplayByUser = %+v", by_user)
+			}
+
+			by_session, err := q.GetBestRunReplayBySession(ctx, GetBestRunReplayBySessionParams{SessionID: session_id, Mode: "normal"})
+			check("GetBestRunReplayBySession", err)
+			if by_session.ID != run_id {
+				t.Errorf("GetBestRunReplayBySession = %+v", by_session)
+			}
+
+			run_replay, err := q.GetRunReplay(ctx, run_id)
+			check("GetRunReplay", err)
+			if run_replay.Score != 12 || run_replay.Gameplay != `{"Gravity":1}` {
+				t.Errorf("GetRunReplay = %+v", run_replay)
+			}
+
+			all, err := q.ListRunReplays(ctx)
+			check("ListRunReplays", err)
+			found = false
+			for _, row := range all {
+				found = found || row.ID == run_id
+			}
+			if !found {
+				t.Error("ListRunReplays misses the run")
+			}
+
+			// Transactions get the dialect's statements too
+			tx, err := db.BeginTx(ctx, nil)
+			check("BeginTx", err)
+			count, err = q.WithTx(tx).CountRunsByUser(ctx, user_id)
+			check("WithTx CountRunsByUser", err)
+			if count != 1 {
+				t.Errorf("WithTx CountRunsByUser = %d, want 1", count)
+			}
+			check("Rollback", tx.Rollback())
+
+			check("DeleteReplay", q.DeleteReplay(ctx, run_id))
+			check("DeleteRun", q.DeleteRun(ctx, run_id))
+
+			_, err = q.GetRunReplay(ctx, run_id)
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("deleted run still found: %v", err)
+			}
+		})
+	}
+}
+
+func TestUniqueViolation(t *testing.T) {
+	for name, config := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			q := New(openTestDatabase(t, config))
+
+			name := utils.GenID(12)
+			now := time.Now().UTC().Format(time.RFC3339)
+
+			err := q.CreateUser(ctx, CreateUserParams{ID: utils.GenID(16), Name: name, CreatedAt: now, UpdatedAt: now})
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+
+			err = q.CreateUser(ctx, CreateUserParams{ID: utils.GenID(16), Name: name, CreatedAt: now, UpdatedAt: now})
+			if !IsUniqueViolation(err) {
+				t.Errorf("second user named %s: %v, want a unique violation", name, err)
+			}
+		})
+	}
+}
+
+// Transactions serialized on one key each see what the ones before them
+// wrote, so exactly one of them finds no daily run yet
+func TestSerialized(t *testing.T) {
+	databases := testDatabases(t)
+	// Every connection to :memory: is the same one, a file lets them overlap
+	databases["sqlite"] = Config{Dialect: SQLite, DSN: t.TempDir() + "/serialized.db"}
+
+	for name, config := range databases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			connection := &Connection{
+				DB:      openTestDatabase(t, config),
+				Dialect: config.Dialect,
+			}
+			connection.Queries = New(connection.DB)
+
+			user_id := utils.GenID(16)
+			day := "2001-" + utils.GenID(5)
+			now := time.Now().UTC().Format(time.RFC3339)
+
+			wait := sync.WaitGroup{}
+			for i := 0; i < 8; i++ {
+				wait.Add(1)
+				go func() {
+					defer wait.Done()
+					err := connection.Serialized(ctx, "run:user:"+user_id, func(q *Queries) error {
+						count, err := q.CountDailyRunsByUser(ctx, CountDailyRunsByUserParams{Daily: day, UserID: user_id})
+						if err != nil {
+							return err
+						}
+
+						counted := int64(0)
+						if count == 0 {
+							counted = 1
+						}
+
+						return q.CreateRun(ctx, CreateRunParams{
+							ID: utils.GenID(16), UserID: user_id, SessionID: utils.GenID(16), Score: 1,
+							CreatedAt: now, Mode: "normal", Daily: day, Counted: counted,
+						})
+					})
+					if err != nil {
+						t.Errorf("Serialized: %v", err)
+					}
+				}()
+			}
+			wait.Wait()
+
+			daily, err := connection.ListTopDailyRuns(ctx, ListTopDailyRunsParams{Daily: day, Limit: 10})
+			if err != nil {
+				t.Fatalf("ListTopDailyRuns: %v", err)
+			}
+			if len(daily) != 1 {
+				t.Errorf("%d runs counted, want 1", len(daily))
+			}
+
+			failed := errors.New("failed")
+			err = connection.Serialized(ctx, "run:user:"+user_id, func(q *Queries) error {
+				err := q.CreateRun(ctx, CreateRunParams{
+					ID: utils.GenID(16), UserID: user_id, SessionID: utils.GenID(16), Score: 1,
+					CreatedAt: now, Mode: "normal", Daily: day, Counted: 1,
+				})
+				if err != nil {
+					return err
+				}
+				return failed
+			})
+			if !errors.Is(err, failed) {
+				t.Errorf("Serialized returned %v, want the error of fn", err)
+			}
+
+			count, err := connection.CountRunsByUser(ctx, user_id)
+			if err != nil {
+				t.Fatalf("CountRunsByUser: %v", err)
+			}
+			if count != 8 {
+				t.Errorf("%d runs stored, want 8 as the failed one is rolled back", count)
+			}
+		})
+	}
+}
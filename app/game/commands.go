@@ -0,0 +1,268 @@
+package game
+
+import (
+	"time"
+
+	"github.com/deastl/flappybird-htmx/metrics"
+)
+
+// Hands the game state to its own goroutine, from then on it may only be
+// used through the commands below
+func (s *GameState) Start() {
+	s.publishFrame()
+	go s.run()
+}
+
+// The owner of a started game state, runs commands one at a time until the
+// session is stopped and then keeps what it last showed in final
+func (s *GameState) run() {
+	defer close(s.exited)
+
+	for {
+		select {
+		case command := <-s.commands:
+			command()
+		case <-s.stopped:
+			s.final = s.snapshot()
+			return
+		}
+	}
+}
+
+// Hands command to the owner without waiting for it to run, reports false
+// when the owner has already exited
+func (s *GameState) post(command func()) bool {
+	select {
+	case s.commands <- command:
+		return true
+	case <-s.exited:
+		return false
+	}
+}
+
+// Runs command on the owner and waits for it to finish, reports false
+// without running it when the owner has already exited
+func (s *GameState) do(command func()) bool {
+	done := make(chan struct{})
+
+	if !s.post(func() { command(); close(done) }) {
+		return false
+	}
+
+	<-done
+
+	return true
+}
+
+// Ends the session, its physics updates and any open frame streams. Safe to
+// call more than once and from the owner itself
+func (s *GameState) Stop() {
+	s.stop_once.Do(func() {
+		close(s.stopped)
+	})
+}
+
+// Closed once the session has been stopped
+func (s *GameState) Done() <-chan struct{} {
+	return s.stopped
+}
+
+func (s *GameState) Stopped() bool {
+	select {
+	case <-s.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// What the session shows right now, or showed when it was stopped
+func (s *GameState) Snapshot() *Snapshot {
+	var snapshot *Snapshot
+
+	if !s.do(func() { snapshot = s.snapshot() }) {
+		return s.finalSnapshot()
+	}
+
+	return snapshot
+}
+
+func (s *GameState) finalSnapshot() *Snapshot {
+	<-s.exited
+	return s.final
+}
+
+// Steps the simulation steps times, publishes the frame it ends on and wakes
+// the tick listeners, done is called once that happened or right away when
+// the session has stopped. The session is stopped once the dead screen is due
+func (s *GameState) Advance(steps int, done func()) {
+	posted := s.post(func() {
+		started := time.Now()
+		for i := 0; i < steps; i++ {
+			s.Step()
+		}
+		s.StepDuration = time.Since(started)
+
+		s.countFrames()
+		s.publishFrame()
+		s.notifyTick()
+		done()
+
+		if s.dead_screen {
+			s.Stop()
+		}
+	})
+
+	if !posted {
+		done()
+	}
+}
+
+// Starts the run if needed and queues a jump for the next tick
+func (s *GameState) Jump() {
+	s.do(s.applyJump)
+}
+
+// The frame published after the latest tick, or the last one once the
+// session has stopped. Safe to call from any goroutine without waiting on
+// the owner
+func (s *GameState) Frame() *FrameSnapshot {
+	return s.frame.Load()
+}
+
+// Samples the FPS from the frames the handlers delivered and starts the
+// dead screen timer once the player died
+func (s *GameState) countFrames() {
+	if s.FrameTimer == nil {
+		s.FrameTimer = time.NewTimer(3 * time.Second)
+		s.FrameCount = s.deliveredFrames()
+	}
+
+	delivered := s.deliveredFrames()
+
+	select {
+	case <-s.FrameTimer.C:
+		frames := delivered - s.FrameCount
+		s.FPS = frames / 3
+		s.FrameCount = delivered
+		s.FrameTimer.Reset(3 * time.Second)
+		if frames > 0 {
+			metrics.AchievedFPS.Observe(float64(s.FPS))
+			metrics.TargetFPS.Observe(float64(s.TargetFPS))
+		}
+	default:
+	}
+
+	s.TotalFrameCount = delivered - s.run_first_frame
+
+	if s.Player.Dead && s.DeadScreenTimer == nil {
+		s.DeadScreenTimer = time.NewTimer(10 * time.Second)
+	}
+
+	if s.DeadScreenTimer != nil {
+		select {
+		case <-s.DeadScreenTimer.C:
+			s.dead_screen = true
+		default:
+		}
+	}
+}
+
+// Puts a dead player at the start of a new course from seed, or of the best
+// run's course when racing it. Reports false when the player is still alive
+// or the session has stopped
+func (s *GameState) Restart(seed int64, day string) bool {
+	restarted := false
+
+	s.do(func() {
+		if !s.Player.Dead {
+			return
+		}
+
+		if s.BestRun != nil {
+			s.BestRun = s.BestRun.Rewound()
+			seed = s.BestRun.GameState.Seed
+		}
+
+		s.Day = day
+		s.reset(seed)
+		s.publishFrame()
+		restarted = true
+	})
+
+	return restarted
+}
+
+func (s *GameState) SetTargetFPS(fps int) {
+	s.do(func() {
+		s.setTargetFPS(fps)
+		s.publishFrame()
+	})
+}
+
+// Switches to another transport and returns the snapshot to render its
+// screen frame from
+func (s *GameState) SetTransport(transport string) *Snapshot {
+	var snapshot *Snapshot
+
+	if !s.do(func() {
+		s.Transport = transport
+		s.publishFrame()
+		snapshot = s.snapshot()
+	}) {
+		return s.finalSnapshot()
+	}
+
+	return snapshot
+}
+
+func (s *GameState) ToggleDebug() {
+	s.do(func() {
+		s.DebugMode = !s.DebugMode
+		s.publishFrame()
+	})
+}
+
+// Drops the ghost of the best run, reports whether there was one
+func (s *GameState) StopRacingBest() bool {
+	racing := false
+
+	s.do(func() {
+		racing = s.BestRun != nil
+		s.BestRun = nil
+	})
+
+	return racing
+}
+
+// Shows the personal best after run, unless the player has moved on to the
+// next run since
+func (s *GameState) setPersonalBest(run int, best int, new_best bool) {
+	s.do(func() {
+		if s.runs != run {
+			return
+		}
+		s.PersonalBest = best
+		s.NewBest = new_best
+		s.publishFrame()
+	})
+}
+
+// Lets the player start after countdown, jumps are ignored until then
+func (s *GameState) startCountdown(countdown time.Duration) {
+	s.do(func() {
+		s.start_at_tick = s.Tick + int(countdown/s.gameplay.TickDuration)
+	})
+}
+
+// Returns a channel that is signalled after every physics tick, call the
+// returned func to stop listening
+func (s *GameState) ListenTicks() (chan struct{}, func()) {
+	listener := make(chan struct{}, 1)
+
+	s.do(func() { s.tick_listeners[listener] = true })
+
+	return listener, func() {
+		s.do(func() { delete(s.tick_listeners, listener) })
+	}
+}
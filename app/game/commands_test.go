@@ -0,0 +1,237 @@
+package game
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/config"
+)
+
+// Drives started sessions from as many goroutines as the server would, run
+// with go test -race to check only the owners touch the live game states
+func TestSessionCommandsConcurrently(t *testing.T) {
+	gameplay := config.DefaultGameplay()
+
+	room := newRoom(DefaultMode)
+	sessions := []*GameState{}
+
+	for i := 0; i < 4; i++ {
+		game_state := NewSeededGameState(gameplay, room.Seed)
+		// The first two race each other, the others play alone
+		if i < 2 {
+			game_state.Room = room
+			game_state.hold_start = true
+			if err := room.join(game_state); err != nil {
+				t.Fatalf("join room: %v", err)
+			}
+		}
+		sessions = append(sessions, game_state)
+	}
+
+	for _, game_state := range sessions {
+		game_state.Start()
+	}
+
+	done := make(chan struct{})
+	wait := sync.WaitGroup{}
+
+	repeat := func(every time.Duration, action func()) {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			ticker := time.NewTicker(every)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					action()
+				}
+			}
+		}()
+	}
+
+	// What the scheduler does every tick
+	repeat(time.Millisecond, func() {
+		ticked := sync.WaitGroup{}
+		ticked.Add(len(sessions))
+		for _, game_state := range sessions {
+			game_state.Advance(2, ticked.Done)
+		}
+		ticked.Wait()
+	})
+	repeat(50*time.Millisecond, room.start)
+
+	for i, game_state := range sessions {
+		in_room := i < 2
+
+		repeat(20*time.Millisecond, game_state.Jump)
+		repeat(time.Millisecond, func() {
+			snapshot := game_state.Snapshot()
+			if snapshot.InRoom != in_room {
+				t.Errorf("snapshot in room = %v, want %v", snapshot.InRoom, in_room)
+			}
+		})
+		repeat(time.Millisecond, func() {
+			frame := game_state.Frame()
+			game_state.countFrame(frame.Transport, len(frame.Pipes), false)
+		})
+		repeat(5*time.Millisecond, func() { game_state.SetTransport(TransportSSE) })
+		repeat(5*time.Millisecond, game_state.ToggleDebug)
+		if !in_room {
+			repeat(200*time.Millisecond, func() { game_state.Restart(int64(i), "") })
+		}
+
+		ticks, stop_listening := game_state.ListenTicks()
+		repeat(time.Millisecond, func() {
+			select {
+			case <-ticks:
+			case <-time.After(10 * time.Millisecond):
+			}
+		})
+		defer stop_listening()
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	for _, game_state := range sessions {
+		if game_state.Frame().Tick == 0 {
+			t.Error("session never advanced")
+		}
+		game_state.Stop()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(done)
+	wait.Wait()
+
+	for _, game_state := range sessions {
+		if !game_state.Stopped() {
+			t.Fatal("session not stopped")
+		}
+		if game_state.Restart(1, "") {
+			t.Error("stopped session restarted")
+		}
+		if game_state.Snapshot() == nil {
+			t.Error("stopped session has no final snapshot")
+		}
+		if game_state.Frame() == nil {
+			t.Error("stopped session has no last frame")
+		}
+
+		advanced := make(chan struct{})
+		game_state.Advance(1, func() { close(advanced) })
+		select {
+		case <-advanced:
+		case <-time.After(time.Second):
+			t.Error("advance on a stopped session never called done")
+		}
+	}
+
+	if !room.Started {
+		t.Error("room never started")
+	}
+}
+
+// Commands posted while the owner exits either run or report that they
+// didn't, they never block
+func TestStopWhileCommandsPending(t *testing.T) {
+	game_state := NewSeededGameState(config.DefaultGameplay(), 1)
+	game_state.Start()
+
+	wait := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				game_state.Jump()
+				game_state.Snapshot()
+				game_state.SetTargetFPS(j)
+			}
+		}()
+	}
+
+	game_state.Stop()
+	game_state.Stop()
+
+	finished := make(chan struct{})
+	go func() {
+		wait.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("commands blocked after stop")
+	}
+}
+
+// A live run can't be dropped, it would never be recorded
+func TestRestartOnlyWhenDead(t *testing.T) {
+	game_state := NewSeededGameState(config.DefaultGameplay(), 1)
+	game_state.Start()
+	defer game_state.Stop()
+
+	game_state.Jump()
+	if game_state.Restart(2, "") {
+		t.Error("restarted a live run")
+	}
+
+	advanceUntilDead(t, game_state)
+	if !game_state.Restart(2, "") {
+		t.Error("dead player could not restart")
+	}
+	if frame := game_state.Frame(); frame.Player.Dead || frame.Player.Started {
+		t.Errorf("restarted player = %+v", frame.Player)
+	}
+}
+
+// Steps a started session until its player fell to the floor
+func advanceUntilDead(t *testing.T, game_state *GameState) {
+	t.Helper()
+
+	for i := 0; i < 1000 && !game_state.Frame().Player.Dead; i++ {
+		advanced := make(chan struct{})
+		game_state.Advance(1, func() { close(advanced) })
+		<-advanced
+	}
+
+	if !game_state.Frame().Player.Dead {
+		t.Fatal("player never died")
+	}
+}
+
+// The personal best arrives after a database round trip, by then the player
+// may already be on the next run
+func TestPersonalBestOfEarlierRunDropped(t *testing.T) {
+	game_state := NewSeededGameState(config.DefaultGameplay(), 1)
+	results := make(chan RunResult, 2)
+	game_state.OnGameOver = func(result RunResult) { results <- result }
+	game_state.Start()
+	defer game_state.Stop()
+
+	game_state.Jump()
+	advanceUntilDead(t, game_state)
+	first := <-results
+
+	game_state.Restart(2, "")
+	game_state.setPersonalBest(first.Run, 50, true)
+
+	if frame := game_state.Frame(); frame.PersonalBest != 0 || frame.NewBest {
+		t.Errorf("next run shows the best of the earlier one: %d, %v", frame.PersonalBest, frame.NewBest)
+	}
+
+	game_state.Jump()
+	advanceUntilDead(t, game_state)
+	second := <-results
+
+	game_state.setPersonalBest(second.Run, 50, true)
+
+	if frame := game_state.Frame(); frame.PersonalBest != 50 || !frame.NewBest {
+		t.Errorf("run shows best %d, %v, want 50, true", frame.PersonalBest, frame.NewBest)
+	}
+}
@@ -0,0 +1,32 @@
+package game
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// Day of the daily challenge at t, challenges roll over at midnight UTC
+func dailyDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// Seed of a day's challenge course, the same for everyone on that day
+func DailySeed(day string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte("daily:" + day))
+
+	return int64(hash.Sum64() >> 1)
+}
+
+// Picks the seed of a new course in mode, returning the day as well when it
+// is the daily challenge
+func courseFor(mode Mode, now time.Time) (int64, string) {
+	if !mode.Daily() {
+		return rand.Int63(), ""
+	}
+
+	day := dailyDay(now)
+
+	return DailySeed(day), day
+}
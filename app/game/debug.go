@@ -0,0 +1,28 @@
+package game
+
+import (
+	"errors"
+	"net/http"
+)
+
+var ErrDebugDisabled = errors.New("the debug overlay is turned off on this server")
+
+// Turns the session's collider boxes and debug overlay on or off, they show
+// up from the next frame on
+func (s *ServerState) PlayerToggledDebug(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	if !s.Config.Server.DebugOverlay {
+		return ErrDebugDisabled
+	}
+
+	game_state.ToggleDebug()
+
+	w.WriteHeader(200)
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package game
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Entity tag of the frame with seq, clients send it back in If-None-Match
+func frameETag(seq int) string {
+	return `"` + strconv.Itoa(seq) + `"`
+}
+
+// Whether an If-None-Match header names the frame with seq, weak tags match
+// as well since frames are compared by what they show
+func etagMatches(if_none_match string, seq int) bool {
+	for _, etag := range strings.Split(if_none_match, ",") {
+		etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+		if etag == frameETag(seq) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package game
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// An element of the screen that a delta frame swaps on its own, its
+// template renders the element's content
+type framePart struct {
+	ID       string
+	Template string
+	Data     any
+}
+
+// Whether a client showing previous has to be sent a full frame, because it
+// shows nothing yet or another course than current
+func needsFullFrame(previous *FrameSnapshot, current *FrameSnapshot) bool {
+	if previous == nil || len(previous.Pipes) != len(current.Pipes) {
+		return true
+	}
+
+	for i := range current.Pipes {
+		if previous.Pipes[i].ID != current.Pipes[i].ID {
+			return true
+		}
+	}
+
+	return previous.Mode != current.Mode ||
+		previous.ReplayID != current.ReplayID ||
+		previous.Transport != current.Transport
+}
+
+// Whether a client showing previous would see nothing new in current
+func sameFrame(previous *FrameSnapshot, current *FrameSnapshot) bool {
+	return !needsFullFrame(previous, current) && len(changedFrameParts(previous, current)) == 0
+}
+
+// The parts of the screen that look different in current than in previous,
+// which must show the same course
+func changedFrameParts(previous *FrameSnapshot, current *FrameSnapshot) []framePart {
+	parts := []framePart{}
+
+	for i, pipe := range current.Pipes {
+		old_pipe := previous.Pipes[i]
+		// Hidden pipes look the same wherever they are
+		if !pipe.Visible && !old_pipe.Visible {
+			continue
+		}
+		if pipe.X != old_pipe.X || pipe.Y != old_pipe.Y || pipe.BottomY != old_pipe.BottomY || pipe.Visible != old_pipe.Visible {
+			parts = append(parts, framePart{"frame-pipe-" + pipe.ID, "templates/pipe.tmpl.css", pipe})
+		}
+	}
+
+	player, old_player := current.Player, previous.Player
+	if player.X != old_player.X || player.Y != old_player.Y || player.Rot != old_player.Rot {
+		parts = append(parts, framePart{"frame-player", "templates/player.tmpl.css", player})
+	}
+
+	if current.BackgroundOffset != previous.BackgroundOffset || current.BackgroundGroundOffset != previous.BackgroundGroundOffset {
+		parts = append(parts, framePart{"frame-background", "templates/background.tmpl.css", current})
+	}
+
+	if current.Points != previous.Points || current.FPS != previous.FPS {
+		parts = append(parts, framePart{"frame-score", "templates/score.tmpl.html", current})
+	}
+
+	// The overlay shows the tick, so it changes with every frame it is on
+	if current.DebugMode || previous.DebugMode {
+		parts = append(parts, framePart{"frame-debug", "templates/debug-overlay.tmpl.html", current})
+	}
+
+	if !slices.Equal(current.Ghosts, previous.Ghosts) {
+		parts = append(parts, framePart{"frame-ghosts", "templates/ghosts.tmpl.html", current.Ghosts})
+	}
+
+	if current.Countdown != previous.Countdown ||
+		current.InRoom != previous.InRoom ||
+		player.Started != old_player.Started ||
+		player.Dead != old_player.Dead ||
+		current.PersonalBest != previous.PersonalBest ||
+		current.NewBest != previous.NewBest {
+		parts = append(parts, framePart{"frame-status", "templates/status.tmpl.html", current})
+	}
+
+	return parts
+}
+
+// Renders the changes from previous to current as out of band swaps, a
+// client showing previous applies them without a target of its own
+func (s *ServerState) renderFrameDelta(delta *bytes.Buffer, previous *FrameSnapshot, current *FrameSnapshot) error {
+	for _, part := range changedFrameParts(previous, current) {
+		fmt.Fprintf(delta, `<span hx-swap-oob="innerHTML:#%s">`, part.ID)
+
+		err := s.Templates.ExecuteTemplate(delta, part.Template, part.Data)
+
+		if err != nil {
+			return errors.New("Could not render " + part.ID + ": " + err.Error())
+		}
+
+		delta.WriteString("</span>")
+	}
+
+	fmt.Fprintf(delta, `<span id="frame-seq" data-frame="%d" hx-swap-oob="true"></span>`, current.Seq)
+
+	return nil
+}
+
+// Renders current for a client showing previous, as a delta when it can and
+// as a full frame from full_template otherwise. Reports whether it rendered
+// a delta
+func (s *ServerState) renderFrame(frame *bytes.Buffer, full_template string, previous *FrameSnapshot, current *FrameSnapshot) (bool, error) {
+	if needsFullFrame(previous, current) {
+		err := s.Templates.ExecuteTemplate(frame, full_template, current)
+
+		if err != nil {
+			return false, errors.New("Could not render screen template: " + err.Error())
+		}
+
+		return false, nil
+	}
+
+	return true, s.renderFrameDelta(frame, previous, current)
+}
@@ -0,0 +1,89 @@
+package game
+
+import (
+	"slices"
+	"testing"
+)
+
+// A frame on a two pipe course, only the first pipe on screen
+func testFrame() *FrameSnapshot {
+	return &FrameSnapshot{
+		Seq:    1,
+		Player: Player{X: 200, Y: 300, Started: true},
+		Pipes: []PipeSet{
+			{ID: "a", X: 500, Y: 100, BottomY: 400, Visible: true},
+			{ID: "b", X: 1600, Y: 50, BottomY: 350},
+		},
+		Transport: TransportPoll,
+		Mode:      DefaultMode,
+	}
+}
+
+func TestFrameDelta(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(f *FrameSnapshot)
+		full   bool
+		parts  []string
+	}{
+		{"unchanged", func(f *FrameSnapshot) {}, false, []string{}},
+		{"new seq only", func(f *FrameSnapshot) { f.Seq = 2; f.Tick = 9 }, false, []string{}},
+		{"hidden pipe moved", func(f *FrameSnapshot) { f.Pipes[1].X -= 15 }, false, []string{}},
+		{"visible pipe moved", func(f *FrameSnapshot) { f.Pipes[0].X -= 15 }, false, []string{"frame-pipe-a"}},
+		{"pipe came into view", func(f *FrameSnapshot) { f.Pipes[1].Visible = true }, false, []string{"frame-pipe-b"}},
+		{"player fell", func(f *FrameSnapshot) { f.Player.Y += 4; f.Player.Rot = 0.1 }, false, []string{"frame-player"}},
+		{"background scrolled", func(f *FrameSnapshot) { f.BackgroundOffset -= 1 }, false, []string{"frame-background"}},
+		{"scored", func(f *FrameSnapshot) { f.Points++ }, false, []string{"frame-score"}},
+		{"debug overlay", func(f *FrameSnapshot) { f.DebugMode = true }, false, []string{"frame-debug"}},
+		{"ghost moved", func(f *FrameSnapshot) { f.Ghosts = []Ghost{{X: 200, Y: 310}} }, false, []string{"frame-ghosts"}},
+		{"player died", func(f *FrameSnapshot) { f.Player.Dead = true }, false, []string{"frame-status"}},
+		{"new best", func(f *FrameSnapshot) { f.PersonalBest = 3; f.NewBest = true }, false, []string{"frame-status"}},
+		{
+			"pipe and player",
+			func(f *FrameSnapshot) { f.Pipes[0].X -= 15; f.Player.Y -= 4 },
+			false,
+			[]string{"frame-pipe-a", "frame-player"},
+		},
+		{"pipe recycled", func(f *FrameSnapshot) { f.Pipes[0].ID = "c" }, true, nil},
+		{"pipe missing", func(f *FrameSnapshot) { f.Pipes = f.Pipes[:1] }, true, nil},
+		{"other mode", func(f *FrameSnapshot) { f.Mode = Mode{Difficulty: DifficultyHard} }, true, nil},
+		{"other transport", func(f *FrameSnapshot) { f.Transport = TransportSSE }, true, nil},
+		{"replay", func(f *FrameSnapshot) { f.ReplayID = "run" }, true, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			previous := testFrame()
+			current := testFrame()
+			c.change(current)
+
+			if full := needsFullFrame(previous, current); full != c.full {
+				t.Fatalf("needsFullFrame = %v, want %v", full, c.full)
+			}
+
+			if c.full {
+				if sameFrame(previous, current) {
+					t.Error("sameFrame on a frame that needs a full render")
+				}
+				return
+			}
+
+			ids := []string{}
+			for _, part := range changedFrameParts(previous, current) {
+				ids = append(ids, part.ID)
+			}
+
+			if !slices.Equal(ids, c.parts) {
+				t.Errorf("changedFrameParts = %q, want %q", ids, c.parts)
+			}
+
+			if same := sameFrame(previous, current); same != (len(c.parts) == 0) {
+				t.Errorf("sameFrame = %v with parts %q", same, ids)
+			}
+		})
+	}
+
+	if !needsFullFrame(nil, testFrame()) {
+		t.Error("a client showing nothing was sent a delta")
+	}
+}
@@ -1,12 +1,13 @@
 package game
 
 import (
-	"log"
 	"math/rand"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/deastl/flappybird-htmx/config"
 	"github.com/deastl/flappybird-htmx/game/physics"
 	"github.com/deastl/flappybird-htmx/utils"
 )
@@ -18,34 +19,127 @@ type Event struct {
 	Created time.Time
 }
 
+// Frames and bytes delivered to a client over one transport, delta frames
+// are counted in both and on their own. Unchanged frames the client already
+// showed were not sent and aren't counted in either
+type TransportStats struct {
+	Frames     int
+	Bytes      int
+	Deltas     int
+	DeltaBytes int
+	Unchanged  int
+}
+
+func (t TransportStats) AvgBytes() int {
+	if t.Frames == 0 {
+		return 0
+	}
+	return t.Bytes / t.Frames
+}
+
+// Bytes the delta frames saved, estimated from the average full frame
+func (t TransportStats) SavedBytes() int {
+	full_frames := t.Frames - t.Deltas
+	if full_frames == 0 {
+		return 0
+	}
+	return t.Deltas*(t.Bytes-t.DeltaBytes)/full_frames - t.DeltaBytes
+}
+
+// Share of the bytes full frames would have taken that the deltas saved
+func (t TransportStats) SavedPercent() int {
+	saved := t.SavedBytes()
+	if saved <= 0 {
+		return 0
+	}
+	return saved * 100 / (t.Bytes + saved)
+}
+
+// TransportStats as the handlers count them, while the owner reads them
+type transportCounters struct {
+	frames      atomic.Int64
+	bytes       atomic.Int64
+	deltas      atomic.Int64
+	delta_bytes atomic.Int64
+	unchanged   atomic.Int64
+}
+
+// Summary of a finished run handed to GameState.OnGameOver
+type RunResult struct {
+	Score     int
+	Duration  time.Duration
+	Frames    int
+	EndedAt   time.Time
+	Seed      int64
+	Mode      Mode
+	Day       string
+	JumpTicks []int
+	Gameplay  config.Gameplay // Settings the run was simulated with
+	Run       int             // Which of the session's runs it was
+}
+
+// A session's simulation and what its client has been shown. Once Start
+// has been called only the session's owner goroutine touches it, everything
+// else goes through the commands in commands.go and renders a Snapshot.
+// Game states that are never started, like replays, belong to whichever
+// goroutine created them
 type GameState struct {
 	Player                 Player
 	Pipes                  map[string]*PipeSet
 	PollRate               string
-	DebugMode              bool
+	Transport              string // How frames reach the client, one of the Transport* constants
+	DebugMode              bool   // Draws the colliders and the debug overlay
+	DebugAllowed           bool   // Whether the server lets the session turn on DebugMode
 	Points                 int
 	BackgroundOffset       int
 	BackgroundGroundOffset int
 	ClientsConnected       int
-	ClientAlive            bool
 	FrameTimer             *time.Timer
 	FPS                    int
 	TargetFPS              int
-	FrameCount             int         // Amount of frames requested in current sampling block
-	TotalFrameCount        int         // Total amount of frames requested since beginning of connection
-	DeadScreenTimer        *time.Timer // Time that is set to trigger the dead screen once it expires
-	pipe_hor_offset        int
-	pipe_vert_offset       int
-	pipe_starting_pos      int
-	pipe_variation         int
-	pipe_count             int
+	FrameCount             int             // Frames delivered when the current FPS sampling block started
+	TotalFrameCount        int             // Frames delivered since the run started
+	DeadScreenTimer        *time.Timer     // Time that is set to trigger the dead screen once it expires
+	StepDuration           time.Duration   // Wall time of the session's steps in the last scheduler tick
+	UserID                 string          // Fixed before Start
+	Seed                   int64           // Seeds every random choice of the course, see NewSeededGameState
+	Tick                   int             // Amount of simulation steps since the game state was created
+	StartTick              int             // Tick of the first jump
+	JumpTicks              []int           // Ticks the player jumped on, together with Seed this reproduces the run
+	ReplayID               string          // Set when the game state plays back a recorded run
+	Mode                   Mode            // Difficulty the course was built with, fixed before Start
+	Day                    string          // Day of the daily challenge being played, empty outside of it
+	Room                   *Room           // Set when the session races others on a shared course, fixed before Start
+	BestRun                *Replay         // Set when the session races a ghost of the player's best run
+	PersonalBest           int             // Best score of the player in this mode, filled in once the run is over
+	NewBest                bool            // Whether the run that just ended beat PersonalBest
+	OnGameOver             func(RunResult) // Called once, in its own goroutine, when the player dies
+	gameplay               config.Gameplay
 	in_point_collider      bool
-	Mut                    sync.Mutex
+	game_over              bool
+	tick_listeners         map[chan struct{}]bool
+	rng                    *rand.Rand
+	pipe_order             []string // Pipe IDs in creation order so updates don't depend on map order
+	hold_start             bool     // Ignores jumps until the room countdown is over
+	start_at_tick          int      // Tick the room countdown ends on, 0 without a countdown
+	last_seen              atomic.Int64
+	transport_counters     map[string]*transportCounters // One per transport, counted by the handlers
+	run_first_frame        int                           // Frames delivered before the run started
+	dead_screen            bool                          // Set once the dead screen is due
+	runs                   int                           // Bumped by reset, tells results of earlier runs apart
+	frame                  atomic.Pointer[FrameSnapshot] // Published by the owner after every tick
+	polled                 atomic.Pointer[FrameSnapshot] // Last frame delivered to the polling client
+	commands               chan func()
+	stop_once              sync.Once
+	stopped                chan struct{} // Closed by Stop, the owner exits once it sees it
+	exited                 chan struct{} // Closed by the owner after it took final
+	final                  *Snapshot     // What the session showed when it stopped
 }
 
 func (s *GameState) getFurthestPipe() *PipeSet {
 	furthest := &PipeSet{}
-	for _, pipe := range s.Pipes {
+	for _, id := range s.pipe_order {
+		pipe := s.Pipes[id]
 		if pipe.X > furthest.X {
 			furthest = pipe
 		}
@@ -54,15 +148,15 @@ func (s *GameState) getFurthestPipe() *PipeSet {
 	return furthest
 }
 func (s *GameState) GenInitialPipes() {
-	num_pipes := s.pipe_count
+	num_pipes := s.gameplay.PipeCount
 	for i := 1; i < num_pipes+1; i++ {
-		vert_level := rand.Intn(s.pipe_variation)
+		vert_level := s.rng.Intn(s.gameplay.PipeVariation)
 
 		new_pipe := PipeSet{
 			Y:              vert_level,
-			BottomY:        vert_level + 300,
-			X:              i * (s.pipe_starting_pos + s.pipe_hor_offset),
-			ID:             utils.GenID(12),
+			BottomY:        vert_level + s.gameplay.PipeGap,
+			X:              i * (s.gameplay.PipeStartingPos + s.gameplay.PipeHorOffset),
+			ID:             utils.GenIDFrom(s.rng, 12),
 			Visible:        true,
 			Width:          255,
 			TopPieceHeight: 135,
@@ -85,17 +179,22 @@ func (s *GameState) GenInitialPipes() {
 		new_pipe.BottomCollider.Height = float32(new_pipe.Height)
 
 		on_point_collected := func(name string) {
-			s.Points++
+			// The dead player still falls through pipes, which doesn't score
+			if !s.Player.Dead {
+				s.Points++
+			}
 		}
 
 		new_pipe.PointCollider.OnLeave = on_point_collected
 
 		s.Pipes[new_pipe.ID] = &new_pipe
+		s.pipe_order = append(s.pipe_order, new_pipe.ID)
 	}
 }
 
 func (s *GameState) isColliding() bool {
-	for _, pipe := range s.Pipes {
+	for _, id := range s.pipe_order {
+		pipe := s.Pipes[id]
 		if pipe.BottomCollider.IsColliding(&s.Player.Collider) ||
 			pipe.TopCollider.IsColliding(&s.Player.Collider) {
 			return true
@@ -105,27 +204,55 @@ func (s *GameState) isColliding() bool {
 	return false
 }
 
-func (s *GameState) Update() {
-	s.Mut.Lock()
-	defer s.Mut.Unlock()
+// Advances the simulation by one fixed tick. The outcome only depends on the
+// seed and the ticks jumps were made on, so runs can be reproduced. Only the
+// owner may call it, started sessions are stepped with Advance
+func (s *GameState) Step() {
+	// The countdown ends with a jump so replays see the start like any other
+	if s.hold_start && s.start_at_tick > 0 && s.Tick >= s.start_at_tick {
+		s.hold_start = false
+		s.jump()
+	}
+
+	s.Player.Update(&s.gameplay)
+	s.Update()
+	s.Tick++
+
+	// The ghost starts with the player, both courses stand still until then
+	if s.BestRun != nil && s.Player.Started {
+		s.BestRun.Step()
+	}
 
+	if s.Room != nil {
+		s.Room.setGhost(s, Ghost{
+			X:      s.Player.X,
+			Y:      s.Player.Y,
+			Rot:    s.Player.Rot,
+			Dead:   s.Player.Dead,
+			Points: s.Points,
+		})
+	}
+}
+
+// Moves the pipes and background and checks collisions
+func (s *GameState) Update() {
 	if !s.Player.Dead && s.Player.Started {
 		s.BackgroundOffset -= 1
-		s.BackgroundGroundOffset -= 15
-		for key := range s.Pipes {
-			vert_level := rand.Intn(s.pipe_variation)
-			gap_level := rand.Intn(100)
-
-			bottom_y_offset := vert_level + 150 + gap_level
+		speed := s.pipeSpeed()
+		s.BackgroundGroundOffset -= speed
+		for _, key := range s.pipe_order {
 			new_pipe := s.Pipes[key]
-			new_pipe.X -= 15
+			new_pipe.X -= speed
 			if new_pipe.X < -100 {
 				// If it goes past the screen then send it to the back
+				vert_level := s.rng.Intn(s.gameplay.PipeVariation)
+				gap_level := s.rng.Intn(s.gameplay.PipeGapVariation)
+
 				new_pipe.Visible = false
 				new_pipe.Y = vert_level
-				new_pipe.BottomY = bottom_y_offset
+				new_pipe.BottomY = vert_level + s.pipeMinGap() + gap_level
 				furthest_pipe := s.getFurthestPipe()
-				new_pipe.X = furthest_pipe.X + (s.pipe_hor_offset * 2)
+				new_pipe.X = furthest_pipe.X + (s.gameplay.PipeHorOffset * 2)
 				// If it's outside the screen then we don't show it
 			} else if new_pipe.X < 1500 && new_pipe.X > 0 {
 				new_pipe.Visible = true
@@ -150,43 +277,202 @@ func (s *GameState) Update() {
 	if s.isColliding() {
 		s.Player.Dead = true
 	}
+
+	if s.Player.Dead && !s.game_over {
+		s.game_over = true
+		if s.OnGameOver != nil {
+			go s.OnGameOver(s.runResult())
+		}
+	}
+}
+
+func (s *GameState) runResult() RunResult {
+	return RunResult{
+		Score:     s.Points,
+		Duration:  time.Duration(s.Tick-s.StartTick) * s.gameplay.TickDuration,
+		Frames:    s.TotalFrameCount,
+		EndedAt:   time.Now(),
+		Seed:      s.Seed,
+		Mode:      s.Mode,
+		Day:       s.Day,
+		JumpTicks: append([]int{}, s.JumpTicks...),
+		Gameplay:  s.gameplay,
+		Run:       s.runs,
+	}
+}
+
+// Starts the run if needed and queues a jump for the next tick, unless the
+// room countdown is still running. Only the owner may call it, started
+// sessions jump with Jump
+func (s *GameState) applyJump() {
+	if s.hold_start {
+		return
+	}
+
+	s.jump()
+}
+
+func (s *GameState) jump() {
+	if s.Player.Dead {
+		return
+	}
+
+	if !s.Player.Started {
+		s.StartTick = s.Tick
+	}
+	s.JumpTicks = append(s.JumpTicks, s.Tick)
+	s.Player.Started = true
+	s.Player.Jumping = true
 }
 
-func (s *GameState) SetTargetFPS(fps int) {
-	s.TargetFPS = fps
+// Seconds left until the room countdown ends, 0 when there is none running
+func (s *GameState) Countdown() int {
+	if !s.hold_start || s.start_at_tick == 0 {
+		return 0
+	}
+
+	remaining := time.Duration(s.start_at_tick-s.Tick) * s.gameplay.TickDuration
+
+	return int((remaining + time.Second - 1) / time.Second)
+}
+
+// The other players of the room and the best run ghost, if enabled
+func (s *GameState) Ghosts() []Ghost {
+	ghosts := []Ghost{}
+
+	if s.Room != nil {
+		ghosts = s.Room.ghostsFor(s)
+	}
+
+	if s.BestRun != nil {
+		best_run := s.BestRun.GameState
+		ghosts = append(ghosts, Ghost{
+			X:      best_run.Player.X,
+			Y:      best_run.Player.Y,
+			Rot:    best_run.Player.Rot,
+			Dead:   best_run.Player.Dead,
+			Points: best_run.Points,
+		})
+	}
+
+	return ghosts
+}
+
+// Counts a frame of size bytes delivered over transport, safe to call from
+// any goroutine
+func (s *GameState) countFrame(transport string, size int, delta bool) {
+	counters := s.transport_counters[transport]
+	counters.frames.Add(1)
+	counters.bytes.Add(int64(size))
+
+	if delta {
+		counters.deltas.Add(1)
+		counters.delta_bytes.Add(int64(size))
+	}
+}
+
+// Counts a frame the client over transport already showed, safe to call
+// from any goroutine
+func (s *GameState) countUnchangedFrame(transport string) {
+	s.transport_counters[transport].unchanged.Add(1)
+}
+
+// Frames the client was brought up to date with, whether they had to be sent
+// or not
+func (s *GameState) deliveredFrames() int {
+	frames := int64(0)
+	for _, counters := range s.transport_counters {
+		frames += counters.frames.Load() + counters.unchanged.Load()
+	}
+
+	return int(frames)
+}
+
+func (s *GameState) notifyTick() {
+	for listener := range s.tick_listeners {
+		select {
+		case listener <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Marks the session as seen by its client just now
+func (s *GameState) Touch() {
+	s.last_seen.Store(time.Now().UnixNano())
+}
+
+func (s *GameState) LastSeen() time.Time {
+	return time.Unix(0, s.last_seen.Load())
+}
+
+func (s *GameState) setTargetFPS(fps int) {
+	s.TargetFPS = max(1, fps)
 	s.PollRate = strconv.FormatInt(1000/int64(s.TargetFPS), 10) + "ms"
 }
 
-func NewGameState() *GameState {
+func NewGameState(gameplay config.Gameplay) *GameState {
+	return NewSeededGameState(gameplay, rand.Int63())
+}
+
+// Creates a game state whose course is generated from seed
+func NewSeededGameState(gameplay config.Gameplay, seed int64) *GameState {
 
 	game_state := GameState{
-		Player: Player{
-			Y:      300,
-			X:      200,
-			Width:  50,
-			Height: 32,
-		},
-		DebugMode:         false,
-		ClientAlive:       true,
-		Pipes:             map[string]*PipeSet{},
-		TargetFPS:         30,
-		pipe_vert_offset:  400,
-		pipe_count:        4,
-		pipe_variation:    250,
-		pipe_hor_offset:   300,
-		pipe_starting_pos: 500,
-	}
-
-	game_state.SetTargetFPS(game_state.TargetFPS)
-
-	log.Printf("%+v", &game_state.Player)
-	game_state.Player.Collider = physics.BoundingBox{
-		X:      game_state.Player.X,
-		Y:      game_state.Player.Y,
-		Width:  float32(game_state.Player.Width),
-		Height: float32(game_state.Player.Height),
-	}
-	game_state.GenInitialPipes()
+		DebugMode:          false,
+		Transport:          TransportPoll,
+		transport_counters: map[string]*transportCounters{},
+		tick_listeners:     map[chan struct{}]bool{},
+		commands:           make(chan func()),
+		stopped:            make(chan struct{}),
+		exited:             make(chan struct{}),
+		TargetFPS:          gameplay.TargetFPS,
+		gameplay:           gameplay,
+	}
+
+	for _, transport := range Transports {
+		game_state.transport_counters[transport] = &transportCounters{}
+	}
+
+	game_state.setTargetFPS(game_state.TargetFPS)
+	game_state.Touch()
+	game_state.reset(seed)
 
 	return &game_state
 }
+
+// Puts the player back at the start of a new course generated from seed
+func (s *GameState) reset(seed int64) {
+	s.Player = Player{
+		Y:      float32(s.gameplay.PlayerY),
+		X:      float32(s.gameplay.PlayerX),
+		Width:  s.gameplay.PlayerWidth,
+		Height: s.gameplay.PlayerHeight,
+	}
+	s.Player.Collider = physics.BoundingBox{
+		X:      s.Player.X,
+		Y:      s.Player.Y,
+		Width:  float32(s.Player.Width),
+		Height: float32(s.Player.Height),
+	}
+
+	s.runs++
+	s.Seed = seed
+	s.rng = rand.New(rand.NewSource(seed))
+	s.Pipes = map[string]*PipeSet{}
+	s.pipe_order = nil
+	s.Points = 0
+	s.BackgroundOffset = 0
+	s.BackgroundGroundOffset = 0
+	s.Tick = 0
+	s.StartTick = 0
+	s.JumpTicks = nil
+	s.run_first_frame = s.deliveredFrames()
+	s.TotalFrameCount = 0
+	s.DeadScreenTimer = nil
+	s.dead_screen = false
+	s.NewBest = false
+	s.game_over = false
+
+	s.GenInitialPipes()
+}
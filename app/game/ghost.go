@@ -0,0 +1,96 @@
+package game
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/deastl/flappybird-htmx/services"
+)
+
+// What the ghost toggle shows, GameState is only set when the course was
+// regenerated and the page has to swap to it
+type GhostToggle struct {
+	Enabled   bool
+	Message   string
+	GameState *Snapshot
+}
+
+func (s *ServerState) renderGhostToggle(w http.ResponseWriter, toggle GhostToggle) error {
+	return s.Templates.ExecuteTemplate(w, "templates/ghost-toggle.tmpl.html", toggle)
+}
+
+func (s *ServerState) GhostToggleRequested(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	return s.renderGhostToggle(w, GhostToggle{Enabled: game_state.Snapshot().RacingBest})
+}
+
+// Turns racing against the player's best run on or off. Turning it on
+// regenerates the course from the best run's seed so the ghost's pipes match
+func (s *ServerState) GhostToggled(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	if game_state.StopRacingBest() {
+		return s.renderGhostToggle(w, GhostToggle{Enabled: false})
+	}
+
+	if game_state.Snapshot().Running() {
+		return s.renderGhostToggle(w, GhostToggle{Message: "Finish this run first"})
+	}
+
+	if game_state.Room != nil {
+		return s.renderGhostToggle(w, GhostToggle{Message: "Not available in a room"})
+	}
+
+	if game_state.Mode.Daily() {
+		return s.renderGhostToggle(w, GhostToggle{Message: "Not available in the daily challenge"})
+	}
+
+	session_id, err := sessionID(r)
+
+	if err != nil {
+		return err
+	}
+
+	best_run, err := services.RunGetBest(s.Ctx, s.Dbq, game_state.UserID, session_id, game_state.Mode.Key())
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.renderGhostToggle(w, GhostToggle{Message: "No run to race in this mode yet"})
+	}
+
+	if err != nil {
+		return errors.New("Could not load best run: " + err.Error())
+	}
+
+	// The ghost only follows the course when both are simulated alike
+	ghost_gameplay := ReplayGameplay(game_state.Mode, best_run.Replay, s.Config.Gameplay)
+	if ghost_gameplay != game_state.Mode.Gameplay(s.Config.Gameplay) {
+		return s.renderGhostToggle(w, GhostToggle{Message: "Your best run was played with other settings"})
+	}
+
+	new_game_state, err := s.replaceSessionGameState(r, best_run.Replay.Seed, game_state.Mode, func(new_game_state *GameState) error {
+		new_game_state.BestRun = NewReplay(ghost_gameplay, best_run.Replay.Seed, best_run.Replay.JumpTicks)
+		new_game_state.BestRun.SkipToStart()
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.renderGhostToggle(w, GhostToggle{
+		Enabled:   true,
+		Message:   "Racing your best of " + strconv.Itoa(best_run.Run.Score),
+		GameState: new_game_state.Snapshot(),
+	})
+}
@@ -0,0 +1,29 @@
+package game
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+type contextKey string
+
+const userIDKey contextKey = "user_id"
+
+// Returns a copy of ctx carrying the ID of the permanent user
+func WithUserID(ctx context.Context, user_id string) context.Context {
+	return context.WithValue(ctx, userIDKey, user_id)
+}
+
+// Returns the permanent user ID attached by the session middleware, empty
+// when the request has none
+func UserIDFromContext(ctx context.Context) string {
+	user_id, _ := ctx.Value(userIDKey).(string)
+	return user_id
+}
+
+// Identifies a JWT secret in the token header without revealing it
+func jwtKeyID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
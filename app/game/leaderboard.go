@@ -0,0 +1,133 @@
+package game
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/metrics"
+	"github.com/deastl/flappybird-htmx/models"
+	"github.com/deastl/flappybird-htmx/services"
+)
+
+const leaderboardSize = 10
+
+type Leaderboard struct {
+	Period string
+	Day    string // Day of the daily challenge shown, only set for the daily mode
+	Mode   Mode
+	Modes  []Mode
+	Runs   []models.Run
+}
+
+// Returns the earliest run time included in a leaderboard period
+func leaderboardSince(period string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+
+	switch period {
+	case "", "all":
+		return time.Time{}, nil
+	case "daily":
+		return now.Truncate(24 * time.Hour), nil
+	case "weekly":
+		return now.Add(-7 * 24 * time.Hour), nil
+	}
+
+	return time.Time{}, errors.New("unknown leaderboard period: " + period)
+}
+
+func (s *ServerState) recordRun(session_id string, user_id string, result RunResult) {
+	metrics.Deaths.Inc()
+	metrics.Scores.Observe(float64(result.Score))
+
+	_, err := VerifyRun(result.Gameplay, result.Seed, result.JumpTicks, result.Score)
+
+	if err != nil {
+		log.Printf("Rejected run for %s : %v", session_id, err)
+		return
+	}
+
+	run := models.Run{
+		UserID:    user_id,
+		SessionID: session_id,
+		Score:     result.Score,
+		Duration:  result.Duration,
+		Frames:    result.Frames,
+		Mode:      result.Mode.Key(),
+		Daily:     result.Day,
+		Counted:   true,
+	}
+
+	replay := models.Replay{
+		Seed:      result.Seed,
+		JumpTicks: result.JumpTicks,
+		Gameplay:  &result.Gameplay,
+	}
+
+	err = services.RunRecord(s.Ctx, s.Db, &run, &replay, s.Config.Server.DailyOneAttempt)
+
+	if err != nil {
+		log.Printf("Error recording run for %s : %v", session_id, err)
+		return
+	}
+
+	log.Printf("Recorded run %s for %s with score %d", run.ID, session_id, run.Score)
+}
+
+func (s *ServerState) LeaderboardRequested(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/html")
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "all"
+	}
+
+	since, err := leaderboardSince(period, time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	// Without a mode the leaderboard follows the mode the player is on
+	mode := DefaultMode
+	mode_key := r.URL.Query().Get("mode")
+
+	if mode_key != "" {
+		mode, err = ModeFromKey(mode_key)
+		if err != nil {
+			return err
+		}
+	} else if game_state, err := s.GetSessionGameState(r); err == nil && game_state.Mode.Difficulty != "" {
+		mode = game_state.Mode
+	}
+
+	var runs []models.Run
+	day := ""
+
+	// The daily challenge ranks today's course only, whatever the period
+	if mode.Daily() {
+		day = dailyDay(time.Now())
+		runs, err = services.RunListDaily(s.Ctx, s.Dbq, day, leaderboardSize)
+	} else {
+		runs, err = services.RunListTop(s.Ctx, s.Dbq, since, mode.Key(), leaderboardSize)
+	}
+
+	if err != nil {
+		return errors.New("Could not load leaderboard: " + err.Error())
+	}
+
+	err = s.Templates.ExecuteTemplate(w, "templates/leaderboard.tmpl.html", Leaderboard{
+		Period: period,
+		Day:    day,
+		Mode:   mode,
+		Modes:  Modes(),
+		Runs:   runs,
+	})
+
+	if err != nil {
+		return errors.New("Could not render leaderboard template: " + err.Error())
+	}
+
+	return nil
+}
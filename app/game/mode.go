@@ -0,0 +1,200 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/config"
+)
+
+const (
+	DifficultyEasy   = "easy"
+	DifficultyNormal = "normal"
+	DifficultyHard   = "hard"
+	DifficultyDaily  = "daily" // The normal preset on the course everyone shares for the day
+)
+
+var Difficulties = []string{DifficultyEasy, DifficultyNormal, DifficultyHard, DifficultyDaily}
+
+var ErrUnknownMode = errors.New("unknown mode")
+
+// Difficulty preset a course is played on, runs of different modes are
+// ranked separately
+type Mode struct {
+	Difficulty  string
+	Progressive bool // Speeds up and narrows the gaps as points rise
+}
+
+var DefaultMode = Mode{Difficulty: DifficultyNormal}
+
+// Every mode, in the order they are offered
+func Modes() []Mode {
+	modes := []Mode{}
+	for _, progressive := range []bool{false, true} {
+		for _, difficulty := range Difficulties {
+			mode := Mode{Difficulty: difficulty, Progressive: progressive}
+			if mode.Daily() && progressive {
+				continue
+			}
+			modes = append(modes, mode)
+		}
+	}
+	return modes
+}
+
+func (m Mode) Daily() bool {
+	return m.Difficulty == DifficultyDaily
+}
+
+// Name of the mode's leaderboard bucket, stored with each run
+func (m Mode) Key() string {
+	if m.Progressive {
+		return m.Difficulty + "-progressive"
+	}
+	return m.Difficulty
+}
+
+func (m Mode) String() string {
+	if m.Daily() {
+		return "Daily challenge"
+	}
+
+	name := strings.ToUpper(m.Difficulty[:1]) + m.Difficulty[1:]
+	if m.Progressive {
+		name += ", progressive"
+	}
+	return name
+}
+
+func ModeFromKey(key string) (Mode, error) {
+	difficulty, progressive := strings.CutSuffix(key, "-progressive")
+
+	if !slices.Contains(Difficulties, difficulty) || (difficulty == DifficultyDaily && progressive) {
+		return Mode{}, fmt.Errorf("%w: %s", ErrUnknownMode, key)
+	}
+
+	return Mode{Difficulty: difficulty, Progressive: progressive}, nil
+}
+
+func modeFromRequest(r *http.Request) (Mode, error) {
+	difficulty := r.FormValue("difficulty")
+
+	if !slices.Contains(Difficulties, difficulty) {
+		return Mode{}, fmt.Errorf("%w: %s", ErrUnknownMode, difficulty)
+	}
+
+	return Mode{
+		Difficulty:  difficulty,
+		Progressive: r.FormValue("progressive") != "" && difficulty != DifficultyDaily,
+	}, nil
+}
+
+// Applies the mode's preset on top of the configured gameplay
+func (m Mode) Gameplay(base config.Gameplay) config.Gameplay {
+	gameplay := base
+
+	switch m.Difficulty {
+	case DifficultyEasy:
+		gameplay.PipeSpeed = max(1, base.PipeSpeed*4/5)
+		gameplay.PipeGap = base.PipeGap + 50
+		gameplay.PipeMinGap = base.PipeMinGap + 50
+	case DifficultyHard:
+		gameplay.PipeSpeed = base.PipeSpeed * 4 / 3
+		gameplay.PipeGap = max(base.GapFloor, base.PipeGap-50)
+		gameplay.PipeMinGap = max(base.GapFloor, base.PipeMinGap-30)
+	}
+
+	gameplay.MaxPipeSpeed = max(gameplay.MaxPipeSpeed, gameplay.PipeSpeed)
+	gameplay.Progressive = m.Progressive
+
+	return gameplay
+}
+
+// Pixels the course scrolls per tick, rising with the points in progressive
+// modes
+func (s *GameState) pipeSpeed() int {
+	if !s.gameplay.Progressive {
+		return s.gameplay.PipeSpeed
+	}
+
+	speed := s.gameplay.PipeSpeed + s.Points/s.gameplay.SpeedUpPoints
+
+	return min(speed, s.gameplay.MaxPipeSpeed)
+}
+
+// Smallest gap of the next recycled pipe, narrowing with the points in
+// progressive modes
+func (s *GameState) pipeMinGap() int {
+	if !s.gameplay.Progressive {
+		return s.gameplay.PipeMinGap
+	}
+
+	gap := s.gameplay.PipeMinGap - s.Points*s.gameplay.GapShrink
+
+	return max(gap, min(s.gameplay.GapFloor, s.gameplay.PipeMinGap))
+}
+
+// What the mode picker shows, GameState is only set when the course was
+// regenerated and the page has to swap to it
+type ModePicker struct {
+	Mode         Mode
+	Difficulties []string
+	Message      string
+	GameState    *Snapshot
+}
+
+func (s *ServerState) renderModePicker(w http.ResponseWriter, picker ModePicker) error {
+	picker.Difficulties = Difficulties
+	return s.Templates.ExecuteTemplate(w, "templates/mode-picker.tmpl.html", picker)
+}
+
+func (s *ServerState) ModePickerRequested(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	return s.renderModePicker(w, ModePicker{Mode: game_state.Mode})
+}
+
+// Moves the session to a new course in the chosen mode, unless a run or a
+// room is in progress
+func (s *ServerState) PlayerChangedMode(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	mode, err := modeFromRequest(r)
+
+	if err != nil {
+		return err
+	}
+
+	if game_state.Snapshot().Running() {
+		return s.renderModePicker(w, ModePicker{Mode: game_state.Mode, Message: "Finish this run first"})
+	}
+
+	if game_state.Room != nil {
+		return s.renderModePicker(w, ModePicker{Mode: game_state.Mode, Message: "The room picks the mode"})
+	}
+
+	seed, day := courseFor(mode, time.Now())
+
+	new_game_state, err := s.replaceSessionGameState(r, seed, mode, func(new_game_state *GameState) error {
+		new_game_state.Day = day
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.renderModePicker(w, ModePicker{Mode: mode, GameState: new_game_state.Snapshot()})
+}
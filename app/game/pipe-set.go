@@ -1,8 +1,6 @@
 package game
 
 import (
-	"sync"
-
 	"github.com/deastl/flappybird-htmx/game/physics"
 )
 
@@ -17,6 +15,5 @@ type PipeSet struct {
 	TopCollider    physics.BoundingBox
 	BottomCollider physics.BoundingBox
 	PointCollider  physics.BoundingBox
-	mut            sync.Mutex
 	Visible        bool
 }
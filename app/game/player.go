@@ -2,8 +2,8 @@ package game
 
 import (
 	"log"
-	"sync"
 
+	"github.com/deastl/flappybird-htmx/config"
 	"github.com/deastl/flappybird-htmx/game/physics"
 )
 
@@ -18,7 +18,6 @@ type Player struct {
 	Started  bool
 	Dead     bool
 	Collider physics.BoundingBox
-	mut      sync.Mutex
 }
 
 func NewPlayer(player *Player) {
@@ -30,20 +29,18 @@ func NewPlayer(player *Player) {
 	}
 }
 
-func (s *Player) Update() {
-	s.mut.Lock()
-	defer s.mut.Unlock()
+func (s *Player) Update(gameplay *config.Gameplay) {
 	if s.Started {
-		s.Vel += 0.019
+		s.Vel += float32(gameplay.Gravity)
 
 		if s.Jumping && !s.Dead {
-			s.Vel = -0.19
+			s.Vel = -float32(gameplay.JumpVelocity)
 			s.Jumping = false
 		}
 
-		s.Y += s.Vel * 20
+		s.Y += s.Vel * float32(gameplay.FallScale)
 
-		if s.Y > 1200 {
+		if s.Y > float32(gameplay.FloorY) {
 			s.Dead = true
 		}
 	}
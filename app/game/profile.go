@@ -0,0 +1,91 @@
+package game
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/deastl/flappybird-htmx/models"
+	"github.com/deastl/flappybird-htmx/services"
+)
+
+var ErrProfileNotFound = errors.New("profile not found")
+
+type NameForm struct {
+	Name  string
+	Error string
+}
+
+type Profile struct {
+	User models.User
+	Runs int
+}
+
+func (s *ServerState) renderNameForm(w http.ResponseWriter, user_id string, form_error string) error {
+	form := NameForm{Error: form_error}
+
+	if user_id != "" {
+		user, err := services.UserGetByID(s.Ctx, s.Dbq, user_id)
+		if err != nil {
+			return errors.New("Could not load user: " + err.Error())
+		}
+		form.Name = user.Name
+	}
+
+	return s.Templates.ExecuteTemplate(w, "templates/name-form.tmpl.html", form)
+}
+
+func (s *ServerState) NameFormRequested(w http.ResponseWriter, r *http.Request) error {
+	return s.renderNameForm(w, UserIDFromContext(r.Context()), "")
+}
+
+// Gives the player the display name they asked for, validation problems are
+// shown in the form
+func (s *ServerState) NameClaimed(w http.ResponseWriter, r *http.Request) error {
+	user_id := UserIDFromContext(r.Context())
+
+	if user_id == "" {
+		return s.renderNameForm(w, "", "Names are unavailable right now")
+	}
+
+	err := services.UserClaimName(s.Ctx, s.Dbq, user_id, r.FormValue("name"))
+
+	if errors.Is(err, services.ErrNameInvalid) ||
+		errors.Is(err, services.ErrNameBlocked) ||
+		errors.Is(err, services.ErrNameTaken) {
+		return s.renderNameForm(w, user_id, err.Error())
+	}
+
+	if err != nil {
+		return errors.New("Could not claim name: " + err.Error())
+	}
+
+	return s.renderNameForm(w, user_id, "")
+}
+
+func (s *ServerState) ProfileRequested(w http.ResponseWriter, r *http.Request, name string) error {
+	if name == "" {
+		return ErrProfileNotFound
+	}
+
+	user, err := services.UserGetByName(s.Ctx, s.Dbq, name)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProfileNotFound
+	}
+
+	if err != nil {
+		return errors.New("Could not load profile: " + err.Error())
+	}
+
+	runs, err := services.RunCountByUser(s.Ctx, s.Dbq, user.ID)
+
+	if err != nil {
+		return errors.New("Could not count runs: " + err.Error())
+	}
+
+	return s.Templates.ExecuteTemplate(w, "templates/profile.tmpl.html", Profile{
+		User: user,
+		Runs: runs,
+	})
+}
@@ -0,0 +1,182 @@
+package game
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/config"
+	"github.com/deastl/flappybird-htmx/models"
+	"github.com/deastl/flappybird-htmx/services"
+)
+
+var ErrReplayNotFound = errors.New("replay not found")
+
+// Ticks a replay keeps simulating after the last jump before giving up on
+// the player dying
+const replayTrailingTicks = 10000
+
+// Re-simulates a recorded run from its seed and jump ticks
+type Replay struct {
+	GameState  *GameState
+	jump_ticks []int
+	next_jump  int
+	end_tick   int
+}
+
+func NewReplay(gameplay config.Gameplay, seed int64, jump_ticks []int) *Replay {
+	end_tick := replayTrailingTicks
+	if len(jump_ticks) > 0 {
+		end_tick += jump_ticks[len(jump_ticks)-1]
+	}
+
+	return &Replay{
+		GameState:  NewSeededGameState(gameplay, seed),
+		jump_ticks: jump_ticks,
+		end_tick:   end_tick,
+	}
+}
+
+// Applies the jumps recorded for the current tick and steps the game state,
+// returns false once the run is over
+func (r *Replay) Step() bool {
+	game_state := r.GameState
+
+	if r.Finished() {
+		return false
+	}
+
+	for r.next_jump < len(r.jump_ticks) && r.jump_ticks[r.next_jump] <= game_state.Tick {
+		game_state.applyJump()
+		r.next_jump++
+	}
+
+	game_state.Step()
+
+	return !r.Finished()
+}
+
+// A fresh copy of the replay, back at the first jump
+func (r *Replay) Rewound() *Replay {
+	replay := NewReplay(r.GameState.gameplay, r.GameState.Seed, r.jump_ticks)
+	replay.SkipToStart()
+
+	return replay
+}
+
+func (r *Replay) Finished() bool {
+	return r.GameState.Player.Dead || r.GameState.Tick >= r.end_tick
+}
+
+// Skips the ticks before the first jump, nothing moves until the player starts
+func (r *Replay) SkipToStart() {
+	if len(r.jump_ticks) == 0 {
+		return
+	}
+	for r.GameState.Tick < r.jump_ticks[0] {
+		r.GameState.Step()
+	}
+}
+
+// The settings a stored replay has to be simulated with. Replays recorded
+// before they were stored fall back to the mode's settings in gameplay
+func ReplayGameplay(mode Mode, replay models.Replay, gameplay config.Gameplay) config.Gameplay {
+	if replay.Gameplay != nil {
+		return *replay.Gameplay
+	}
+
+	return mode.Gameplay(gameplay)
+}
+
+func (s *ServerState) loadReplay(run_id string) (*Replay, error) {
+	run_replay, err := services.RunGetWithReplay(s.Ctx, s.Dbq, run_id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReplayNotFound
+	}
+
+	if err != nil {
+		return nil, errors.New("Could not load replay: " + err.Error())
+	}
+
+	mode, err := ModeFromKey(run_replay.Run.Mode)
+
+	if err != nil {
+		return nil, errors.New("Could not load replay: " + err.Error())
+	}
+
+	replay := NewReplay(ReplayGameplay(mode, run_replay.Replay, s.Config.Gameplay), run_replay.Replay.Seed, run_replay.Replay.JumpTicks)
+	replay.GameState.Mode = mode
+
+	return replay, nil
+}
+
+// Renders the game page for a replay, the screen is streamed by ReplayRequestedStream
+func (s *ServerState) ReplayRequested(w http.ResponseWriter, r *http.Request, run_id string) error {
+	replay, err := s.loadReplay(run_id)
+
+	if err != nil {
+		return err
+	}
+
+	replay.GameState.ReplayID = run_id
+
+	return s.Templates.ExecuteTemplate(w, "templates/index.tmpl.html", replay.GameState.snapshot())
+}
+
+// Re-simulates a replay in real time and streams its screen over SSE
+func (s *ServerState) ReplayRequestedStream(w http.ResponseWriter, r *http.Request, run_id string) error {
+	replay, err := s.loadReplay(run_id)
+
+	if err != nil {
+		return err
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("Streaming is not supported by the response writer")
+	}
+
+	startEventStream(w, flusher)
+
+	replay.SkipToStart()
+
+	ticker := time.NewTicker(replay.GameState.gameplay.TickDuration)
+	defer ticker.Stop()
+
+	event := bytes.Buffer{}
+	var delivered *FrameSnapshot
+	running := true
+
+	for running {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		running = replay.Step()
+
+		frame := replay.GameState.frameSnapshot()
+
+		_, err = s.renderScreenEvent(&event, delivered, frame)
+		delivered = frame
+
+		if err != nil {
+			return err
+		}
+
+		_, err = event.WriteTo(w)
+
+		if err != nil {
+			return nil
+		}
+		flusher.Flush()
+	}
+
+	// Swapping out the stream's element keeps EventSource from reconnecting
+	// and starting the replay over
+	return s.endEventStream(w, flusher, delivered)
+}
@@ -0,0 +1,89 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/deastl/flappybird-htmx/config"
+)
+
+// Points the autopilot plays for before it lets the player fall
+const autopilotPoints = 8
+
+// Plays a started session tick by tick the way the handlers do, jumping
+// whenever the player sinks towards the bottom of the next gap, until the
+// player died. Returns the run and the tick the player died on
+func playAutopilot(t *testing.T, game_state *GameState, results chan RunResult) (RunResult, int) {
+	t.Helper()
+
+	game_state.Jump()
+
+	for i := 0; i < 20000; i++ {
+		frame := game_state.Frame()
+		if frame.Player.Dead {
+			return <-results, frame.Tick
+		}
+
+		if frame.Points < autopilotPoints {
+			bottom := float32(game_state.gameplay.PlayerY + 100)
+			next_x := 0
+			for _, pipe := range frame.Pipes {
+				ahead := float32(pipe.X+pipe.Width) > frame.Player.X
+				if ahead && (next_x == 0 || pipe.X < next_x) {
+					next_x = pipe.X
+					bottom = float32(pipe.BottomY - 40)
+				}
+			}
+			if frame.Player.Vel > 0 && frame.Player.Y+float32(frame.Player.Height) > bottom {
+				game_state.Jump()
+			}
+		}
+
+		advanced := make(chan struct{})
+		game_state.Advance(1, func() { close(advanced) })
+		<-advanced
+	}
+
+	t.Fatal("player never died")
+	return RunResult{}, 0
+}
+
+// Plays a run live in mode through the session's commands
+func playLive(t *testing.T, mode Mode, seed int64) (RunResult, int) {
+	t.Helper()
+
+	game_state := NewSeededGameState(mode.Gameplay(config.DefaultGameplay()), seed)
+	game_state.Mode = mode
+	results := make(chan RunResult, 1)
+	game_state.OnGameOver = func(result RunResult) { results <- result }
+	game_state.Start()
+	defer game_state.Stop()
+
+	return playAutopilot(t, game_state, results)
+}
+
+// Replays and score verification only work if a seed and jump ticks always
+// play out the same
+func TestSimulationDeterministic(t *testing.T) {
+	for _, mode := range Modes() {
+		t.Run(mode.Key(), func(t *testing.T) {
+			result, dead_tick := playLive(t, mode, 42)
+
+			if result.Score < autopilotPoints {
+				t.Fatalf("autopilot scored %d, the run proves little", result.Score)
+			}
+
+			for i := 0; i < 2; i++ {
+				replay := NewReplay(result.Gameplay, result.Seed, result.JumpTicks)
+				for replay.Step() {
+				}
+
+				if replay.GameState.Points != result.Score || replay.GameState.Tick != dead_tick {
+					t.Errorf(
+						"replay %d ended on %d points at tick %d, live run on %d at tick %d",
+						i, replay.GameState.Points, replay.GameState.Tick, result.Score, dead_tick,
+					)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package game
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/services"
+)
+
+// Best recorded score of a user, or of a session without a user, in a mode
+func (s *ServerState) personalBest(session_id string, user_id string, mode Mode) int {
+	best_run, err := services.RunGetBest(s.Ctx, s.Dbq, user_id, session_id, mode.Key())
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0
+	}
+
+	if err != nil {
+		log.Printf("Error loading personal best for %s : %v", session_id, err)
+		return 0
+	}
+
+	return best_run.Run.Score
+}
+
+// Renders the dead screen in place of the screen once its frames have stopped.
+// The stopped session may have been evicted since, then its last frame is
+// rendered
+func (s *ServerState) DeadScreenRequested(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/html")
+
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		session_id, id_err := sessionID(r)
+		if id_err != nil {
+			return err
+		}
+
+		last_frame := s.Sessions.LastFrame(session_id)
+		if last_frame == nil {
+			return err
+		}
+
+		return s.Templates.ExecuteTemplate(w, "templates/dead-screen.tmpl.html", last_frame)
+	}
+
+	return s.Templates.ExecuteTemplate(w, "templates/dead-screen.tmpl.html", game_state.Frame())
+}
+
+// Puts the session on a fresh run in the same mode once the player died and
+// swaps the screen back in, keeping the session cookie and user. Restarting
+// a live run is refused with a 409. The game state is reset in place
+// unless the dead screen already stopped it or it is in a room, which the
+// player leaves. A stopped session may have been evicted since, then the
+// mode and transport the dead screen sent along are used instead
+func (s *ServerState) PlayerRestarted(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/html")
+
+	session_id, err := sessionID(r)
+
+	if err != nil {
+		return err
+	}
+
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		new_game_state := s.startSessionGameState(r, session_id)
+		return s.Templates.ExecuteTemplate(w, "templates/restarted.tmpl.html", new_game_state.Snapshot())
+	}
+
+	// A run dropped before the player died would never be recorded, so the
+	// daily challenge could be retried until a run looks good
+	if !game_state.Snapshot().Player.Dead {
+		w.WriteHeader(http.StatusConflict)
+		return nil
+	}
+
+	seed, day := courseFor(game_state.Mode, time.Now())
+
+	if game_state.Room == nil && game_state.Restart(seed, day) {
+		return s.Templates.ExecuteTemplate(w, "templates/restarted.tmpl.html", game_state.Snapshot())
+	}
+
+	new_game_state, err := s.replaceSessionGameState(r, seed, game_state.Mode, func(new_game_state *GameState) error {
+		new_game_state.Day = day
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.Templates.ExecuteTemplate(w, "templates/restarted.tmpl.html", new_game_state.Snapshot())
+}
@@ -0,0 +1,262 @@
+package game
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/utils"
+)
+
+// Time between starting a room and its players starting
+const roomCountdown = 3 * time.Second
+
+var ErrRoomStarted = errors.New("room has already started")
+
+// Pose of another player in the room, rendered as a translucent bird
+type Ghost struct {
+	X      float32
+	Y      float32
+	Rot    float32
+	Dead   bool
+	Points int
+}
+
+// Players racing on one course, every member's game state is generated from
+// Seed and Mode and they start on the same scheduler tick, so their pipes
+// line up
+type Room struct {
+	Code    string
+	Seed    int64
+	Mode    Mode // Taken from whoever created the room
+	Started bool
+	members map[*GameState]Ghost
+	joined  []*GameState // Members in join order, so their ghosts keep their order
+	Mut     sync.Mutex
+}
+
+// What the lobby fragment shows for a session
+type Lobby struct {
+	Room    *Room
+	Members int
+	Started bool
+	Error   string
+}
+
+type roomJoin struct {
+	Lobby     Lobby
+	GameState *Snapshot
+}
+
+func newRoom(mode Mode) *Room {
+	return &Room{
+		Code:    utils.GenID(5),
+		Seed:    rand.Int63(),
+		Mode:    mode,
+		members: map[*GameState]Ghost{},
+	}
+}
+
+func (r *Room) join(game_state *GameState) error {
+	r.Mut.Lock()
+	defer r.Mut.Unlock()
+
+	if r.Started {
+		return ErrRoomStarted
+	}
+
+	r.members[game_state] = Ghost{X: game_state.Player.X, Y: game_state.Player.Y}
+	r.joined = append(r.joined, game_state)
+
+	return nil
+}
+
+// Removes a member and returns how many are left
+func (r *Room) leave(game_state *GameState) int {
+	r.Mut.Lock()
+	defer r.Mut.Unlock()
+
+	delete(r.members, game_state)
+	r.joined = slices.DeleteFunc(r.joined, func(member *GameState) bool { return member == game_state })
+
+	return len(r.members)
+}
+
+func (r *Room) lobby() Lobby {
+	r.Mut.Lock()
+	defer r.Mut.Unlock()
+
+	return Lobby{
+		Room:    r,
+		Members: len(r.members),
+		Started: r.Started,
+	}
+}
+
+func (r *Room) setGhost(member *GameState, ghost Ghost) {
+	r.Mut.Lock()
+	defer r.Mut.Unlock()
+
+	if _, ok := r.members[member]; ok {
+		r.members[member] = ghost
+	}
+}
+
+func (r *Room) ghostsFor(member *GameState) []Ghost {
+	r.Mut.Lock()
+	defer r.Mut.Unlock()
+
+	ghosts := make([]Ghost, 0, len(r.members))
+	for _, other := range r.joined {
+		if other != member {
+			ghosts = append(ghosts, r.members[other])
+		}
+	}
+
+	return ghosts
+}
+
+// Starts the countdown of every member, this has to run between scheduler
+// ticks so all members start on the same tick
+func (r *Room) start() {
+	r.Mut.Lock()
+	if r.Started {
+		r.Mut.Unlock()
+		return
+	}
+	r.Started = true
+
+	members := slices.Clone(r.joined)
+	r.Mut.Unlock()
+
+	for _, member := range members {
+		member.startCountdown(roomCountdown)
+	}
+}
+
+// Takes a game state out of its room and drops the room once it is empty
+func (s *ServerState) leaveRoom(game_state *GameState) {
+	room := game_state.Room
+
+	if room == nil {
+		return
+	}
+
+	if room.leave(game_state) == 0 {
+		s.Rooms.Delete(room.Code)
+	}
+}
+
+func (s *ServerState) renderLobby(w http.ResponseWriter, game_state *GameState, lobby_error string) error {
+	lobby := Lobby{}
+
+	if game_state.Room != nil {
+		lobby = game_state.Room.lobby()
+	}
+	lobby.Error = lobby_error
+
+	return s.Templates.ExecuteTemplate(w, "templates/lobby.tmpl.html", lobby)
+}
+
+// Moves the session onto a fresh game state on the room's course and swaps
+// the pipes and screen of the page over to it
+func (s *ServerState) joinRoom(w http.ResponseWriter, r *http.Request, room *Room) error {
+	old_game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	new_game_state, err := s.replaceSessionGameState(r, room.Seed, room.Mode, func(new_game_state *GameState) error {
+		new_game_state.Room = room
+		new_game_state.hold_start = true
+		return room.join(new_game_state)
+	})
+
+	if errors.Is(err, ErrRoomStarted) {
+		return s.renderLobby(w, old_game_state, "Could not join room "+room.Code+": "+err.Error())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.Templates.ExecuteTemplate(w, "templates/room-joined.tmpl.html", roomJoin{
+		Lobby:     room.lobby(),
+		GameState: new_game_state.Snapshot(),
+	})
+}
+
+func (s *ServerState) LobbyRequested(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	return s.renderLobby(w, game_state, "")
+}
+
+func (s *ServerState) RoomCreated(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	// Rooms race on their own course, so the daily challenge can't be
+	// played in one
+	mode := game_state.Mode
+	if mode.Daily() {
+		mode = DefaultMode
+	}
+
+	room := newRoom(mode)
+	s.Rooms.Store(room.Code, room)
+
+	err = s.joinRoom(w, r, room)
+
+	// Nobody would ever leave a room nobody joined, which is what drops it
+	if err != nil && room.lobby().Members == 0 {
+		s.Rooms.Delete(room.Code)
+	}
+
+	return err
+}
+
+func (s *ServerState) RoomJoined(w http.ResponseWriter, r *http.Request) error {
+	code := r.FormValue("code")
+
+	stored_room, ok := s.Rooms.Load(code)
+
+	if !ok {
+		game_state, err := s.GetSessionGameState(r)
+		if err != nil {
+			return err
+		}
+		// The lobby is rendered with text/template, which leaves the code as is
+		return s.renderLobby(w, game_state, "No room with code "+template.HTMLEscapeString(code))
+	}
+
+	return s.joinRoom(w, r, stored_room.(*Room))
+}
+
+func (s *ServerState) RoomStarted(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	if game_state.Room == nil {
+		return s.renderLobby(w, game_state, "Join a room first")
+	}
+
+	s.Scheduler.Between(game_state.Room.start)
+
+	return s.renderLobby(w, game_state, "")
+}
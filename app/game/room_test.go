@@ -0,0 +1,44 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/deastl/flappybird-htmx/config"
+)
+
+// Ghosts come in join order, so a room where nobody moves publishes the
+// same frame tick after tick
+func TestRoomGhostsKeepOrder(t *testing.T) {
+	room := newRoom(DefaultMode)
+	members := []*GameState{}
+
+	for i := 0; i < 5; i++ {
+		game_state := NewSeededGameState(config.DefaultGameplay(), room.Seed)
+		game_state.Room = room
+		if err := room.join(game_state); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		room.setGhost(game_state, Ghost{X: float32(i), Y: float32(10 * i)})
+		members = append(members, game_state)
+	}
+
+	room.leave(members[2])
+
+	viewer := members[0]
+	viewer.publishFrame()
+	first := viewer.Frame()
+
+	want := []float32{1, 3, 4}
+	for i, ghost := range first.Ghosts {
+		if ghost.X != want[i] {
+			t.Fatalf("ghosts = %+v, want them in join order", first.Ghosts)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		viewer.publishFrame()
+		if viewer.Frame().Seq != first.Seq {
+			t.Fatalf("frame %d got seq %d, want %d as nothing changed", i, viewer.Frame().Seq, first.Seq)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package game
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/metrics"
+)
+
+// How many ticks the scheduler may fall behind before skipped ticks are
+// dropped instead of being caught up
+const maxTickLag = 5
+
+// Amount of sessions a worker steps per batch
+const tickBatchSize = 256
+
+type SchedulerStats struct {
+	Ticks            int64
+	Overruns         int64 // Ticks whose work took longer than the tick duration
+	DroppedTicks     int64 // Ticks skipped because the scheduler fell too far behind
+	LastTickDuration time.Duration
+	MaxTickDuration  time.Duration
+}
+
+type tickBatch struct {
+	game_states []*GameState
+	steps       int
+}
+
+// Steps every live session on one shared fixed timestep, spreading the
+// sessions over a fixed pool of worker goroutines in batches
+type Scheduler struct {
+	Workers            int
+	TickDuration       time.Duration // Simulated time of one physics step
+	states             *sync.Map
+	batches            chan tickBatch
+	batch_group        sync.WaitGroup
+	tick_mut           sync.Mutex // Held while a tick is being processed
+	ticks              atomic.Int64
+	overruns           atomic.Int64
+	dropped_ticks      atomic.Int64
+	last_tick_duration atomic.Int64
+	max_tick_duration  atomic.Int64
+}
+
+func NewScheduler(states *sync.Map, workers int, tick_duration time.Duration) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Scheduler{
+		Workers:      workers,
+		TickDuration: tick_duration,
+		states:       states,
+		batches:      make(chan tickBatch),
+	}
+}
+
+// Hands each session of a batch its steps, the owners run them in parallel
+// and the batch is done once all of them have
+func (sc *Scheduler) work() {
+	for batch := range sc.batches {
+		sc.batch_group.Add(len(batch.game_states))
+		for _, game_state := range batch.game_states {
+			game_state.Advance(batch.steps, sc.batch_group.Done)
+		}
+		sc.batch_group.Done()
+	}
+}
+
+// Runs the tick loop, never returns
+func (sc *Scheduler) Run() {
+	for i := 0; i < sc.Workers; i++ {
+		go sc.work()
+	}
+
+	game_states := []*GameState{}
+	next_tick := time.Now()
+
+	for {
+		time.Sleep(time.Until(next_tick))
+
+		// A late wakeup is caught up with extra steps so scheduler jitter
+		// doesn't change game speed, unless it is too far behind
+		lag := time.Since(next_tick)
+		if lag > maxTickLag*sc.TickDuration {
+			sc.dropped_ticks.Add(int64(lag / sc.TickDuration))
+			next_tick = time.Now()
+		}
+
+		steps := 0
+		for !next_tick.After(time.Now()) {
+			steps++
+			next_tick = next_tick.Add(sc.TickDuration)
+		}
+
+		game_states = game_states[:0]
+		sc.states.Range(func(key any, value any) bool {
+			game_state := value.(*GameState)
+			if !game_state.Stopped() {
+				game_states = append(game_states, game_state)
+			}
+			return true
+		})
+
+		started := time.Now()
+		sc.tick_mut.Lock()
+		sc.tick(game_states, steps)
+		sc.tick_mut.Unlock()
+		sc.recordTick(time.Since(started))
+	}
+}
+
+// Hands the sessions to the workers in batches and waits for all of them
+func (sc *Scheduler) tick(game_states []*GameState, steps int) {
+	for start := 0; start < len(game_states); start += tickBatchSize {
+		end := min(start+tickBatchSize, len(game_states))
+
+		sc.batch_group.Add(1)
+		sc.batches <- tickBatch{
+			game_states: game_states[start:end],
+			steps:       steps,
+		}
+	}
+	sc.batch_group.Wait()
+}
+
+// Runs fn while no tick is in progress, so every session sees its effect
+// from the same tick on
+func (sc *Scheduler) Between(fn func()) {
+	sc.tick_mut.Lock()
+	defer sc.tick_mut.Unlock()
+
+	fn()
+}
+
+func (sc *Scheduler) recordTick(duration time.Duration) {
+	sc.ticks.Add(1)
+	sc.last_tick_duration.Store(int64(duration))
+	metrics.TickDuration.Observe(duration.Seconds())
+
+	if duration > sc.TickDuration {
+		sc.overruns.Add(1)
+		metrics.TickOverruns.Inc()
+	}
+
+	if int64(duration) > sc.max_tick_duration.Load() {
+		sc.max_tick_duration.Store(int64(duration))
+	}
+}
+
+func (sc *Scheduler) Stats() SchedulerStats {
+	return SchedulerStats{
+		Ticks:            sc.ticks.Load(),
+		Overruns:         sc.overruns.Load(),
+		DroppedTicks:     sc.dropped_ticks.Load(),
+		LastTickDuration: time.Duration(sc.last_tick_duration.Load()),
+		MaxTickDuration:  time.Duration(sc.max_tick_duration.Load()),
+	}
+}
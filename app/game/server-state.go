@@ -1,59 +1,103 @@
 package game
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
+	"github.com/deastl/flappybird-htmx/config"
 	"github.com/deastl/flappybird-htmx/db"
+	"github.com/deastl/flappybird-htmx/metrics"
 	"github.com/deastl/flappybird-htmx/utils"
 	"github.com/golang-jwt/jwt"
 )
 
 type ServerState struct {
 	GameStates    sync.Map
+	Sessions      *SessionManager
+	Scheduler     *Scheduler
+	Rooms         sync.Map
 	Templates     *template.Template
-	JWTSecret     string
+	Config        config.Config
+	JWTSecret     string         // Signs new permanent tokens
+	JWTOldSecrets []string       // Still accepted when verifying, so secrets can be rotated
+	Db            *db.Connection // Runs the writes that have to happen together
 	Dbq           *db.Queries
 	Ctx           context.Context
 	Mut           sync.Mutex
-	frames_served int
+	frames_served atomic.Int64
 }
 
 func (s *ServerState) New() {
 	s.Templates = template.New("")
-	default_secret := "this_is_a_fake_secret"
-	env_secret := os.Getenv("jwt_secret")
 
 	if len(s.JWTSecret) == 0 {
-		s.JWTSecret = env_secret
-		if len(s.JWTSecret) == 0 {
-			s.JWTSecret = default_secret
-		}
+		s.JWTSecret = s.Config.Server.JWTSecret
+	}
+
+	if len(s.JWTOldSecrets) == 0 {
+		s.JWTOldSecrets = s.Config.Server.JWTOldSecrets
 	}
 
+	s.Sessions = NewSessionManager(&s.GameStates, s.Config.Server.SessionTTL)
+	s.Sessions.OnEvict = s.leaveRoom
+	go s.Sessions.Run()
+
+	s.Scheduler = NewScheduler(&s.GameStates, s.Config.Server.TickWorkers, s.Config.Gameplay.TickDuration)
+	go s.Scheduler.Run()
+
+	metrics.ObserveActiveSessions(func() float64 {
+		return float64(s.Sessions.Stats().Active)
+	})
+	metrics.ObserveFramesServed(func() float64 {
+		return float64(s.frames_served.Load())
+	})
+
 	s.initTempaltes()
 }
 
 func (s *ServerState) initTempaltes() {
 
 	x := []string{
+		"templates/background.tmpl.css",
 		"templates/bounding-box.tmpl.css",
+		"templates/dead-screen.tmpl.html",
+		"templates/debug-overlay.tmpl.html",
+		"templates/ghost-toggle.tmpl.html",
+		"templates/ghosts.tmpl.html",
 		"templates/index.tmpl.html",
+		"templates/leaderboard.tmpl.html",
+		"templates/lobby.tmpl.html",
+		"templates/mode-picker.tmpl.html",
+		"templates/name-form.tmpl.html",
+		"templates/pipes.tmpl.html",
+		"templates/room-joined.tmpl.html",
 		"templates/pipe.tmpl.css",
 		"templates/player.tmpl.css",
+		"templates/profile.tmpl.html",
+		"templates/restarted.tmpl.html",
+		"templates/score.tmpl.html",
 		"templates/screen.tmpl.html",
 		"templates/screen-frame.tmpl.html",
+		"templates/screen-oob.tmpl.html",
 		"templates/stats.tmpl.html",
+		"templates/status.tmpl.html",
+		"templates/stream-end.tmpl.html",
 	}
 	for _, f := range x {
-		fileContents, err := os.ReadFile(f)
+		// Templates keep their templates/ name whichever directory they are read from
+		file_path := filepath.Join(s.Config.Server.TemplateDir, strings.TrimPrefix(f, "templates/"))
+		fileContents, err := os.ReadFile(file_path)
 		if err != nil {
 			panic(err.Error())
 		}
@@ -67,47 +111,33 @@ func (s *ServerState) initTempaltes() {
 
 func (s *ServerState) LogInfo() {
 	log.Println("---------------------------------")
-	log.Println("       Connected Clients         ")
-	s.GameStates.Range(func(key any, value any) bool {
-		id := key.(string)
-		game_state := value.(*GameState)
-
-		log.Printf(
-			"ID: %s Score: %v PlayerAlive: %t FPS: %d TargetFPS: %d PollRate: %s\n",
-			id,
-			game_state.Points,
-			!game_state.Player.Dead,
-			game_state.FPS,
-			game_state.TargetFPS,
-			game_state.PollRate,
-		)
-		return true
-	})
+	session_stats := s.Sessions.Stats()
+	log.Printf("Active sessions: %d Evicted sessions: %d", session_stats.Active, session_stats.Evicted)
+	scheduler_stats := s.Scheduler.Stats()
+	log.Printf(
+		"Ticks: %d Overruns: %d Dropped: %d Last tick: %s Max tick: %s",
+		scheduler_stats.Ticks,
+		scheduler_stats.Overruns,
+		scheduler_stats.DroppedTicks,
+		scheduler_stats.LastTickDuration,
+		scheduler_stats.MaxTickDuration,
+	)
+	log.Printf("Frames served: %d", s.frames_served.Load())
 }
 
-func (s *ServerState) NewPhysicsSession(session_id string) {
-	go func(session_id string, server_state *ServerState) {
-		delay := 30 * time.Millisecond
-		for {
-			sync_out, ok := s.GameStates.Load(session_id)
-
-			game_state := sync_out.(*GameState)
-
-			if !game_state.ClientAlive {
-				return
-			}
+// The latest frame of game_state and whether the dead screen is due instead
+// of further frames
+func (s *ServerState) frameRequested(game_state *GameState) (*FrameSnapshot, bool) {
+	frame := game_state.Frame()
 
-			if !ok {
-				log.Print("Could not load game state in game loop")
-				return
-			}
-			game_state.Player.Update()
-			game_state.Update()
-			server_state.GameStates.Store(session_id, game_state)
+	return frame, frame.DeadScreen || game_state.Stopped()
+}
 
-			time.Sleep(time.Duration(delay))
-		}
-	}(session_id, s)
+// Counts a rendered frame of size bytes delivered to the client of
+// game_state over transport, delta when it only held the changes
+func (s *ServerState) frameDelivered(game_state *GameState, transport string, size int, delta bool) {
+	game_state.countFrame(transport, size, delta)
+	s.frames_served.Add(1)
 }
 
 func (s *ServerState) PlayerRequestedFrame(w http.ResponseWriter, r *http.Request) error {
@@ -115,46 +145,57 @@ func (s *ServerState) PlayerRequestedFrame(w http.ResponseWriter, r *http.Reques
 
 	game_state, err := s.GetSessionGameState(r)
 
-	if game_state.FrameTimer == nil {
-		game_state.FrameTimer = time.NewTimer(3 * time.Second)
+	if err != nil {
+		return errors.New("Error in get-screen: " + err.Error())
 	}
 
-	select {
-	case <-game_state.FrameTimer.C:
-		game_state.FPS = game_state.FrameCount / 3
-		game_state.FrameCount = 0
-		game_state.FrameTimer.Reset(3 * time.Second)
-	default:
-		game_state.FrameCount++
+	previous := game_state.polled.Load()
+	snapshot, show_dead_screen := s.frameRequested(game_state)
+
+	if show_dead_screen {
+		w.Header().Set("Hx-Trigger", "get-dead-screen")
 	}
 
-	game_state.TotalFrameCount++
+	w.Header().Set("ETag", frameETag(snapshot.Seq))
+	w.Header().Set("Cache-Control", "no-cache")
 
-	if game_state.Player.Dead && game_state.DeadScreenTimer == nil {
-		game_state.DeadScreenTimer = time.NewTimer(10 * time.Second)
-	}
+	// The client names the frame it shows, when that is still the current one
+	// there is nothing to render. htmx would swap the empty body in, so it is
+	// told not to swap at all
+	if_none_match := r.Header.Get("If-None-Match")
 
-	if game_state.DeadScreenTimer != nil {
-		select {
-		case <-game_state.DeadScreenTimer.C:
-			w.Header().Set("Hx-Trigger", "get-dead-screen")
-			game_state.ClientAlive = false
-		default:
-		}
+	if etagMatches(if_none_match, snapshot.Seq) {
+		w.Header().Set("HX-Reswap", "none")
+		w.WriteHeader(http.StatusNotModified)
+		game_state.countUnchangedFrame(TransportPoll)
+		return nil
 	}
 
-	if err != nil {
-		return errors.New("Error in get-screen: " + err.Error())
+	// Any other frame than the last one delivered means the client needs a
+	// full frame again
+	if previous != nil && !etagMatches(if_none_match, previous.Seq) {
+		previous = nil
 	}
 
-	game_state.Mut.Lock()
-	err = s.Templates.ExecuteTemplate(w, "templates/screen.tmpl.html", game_state)
-	game_state.Mut.Unlock()
+	frame := bytes.Buffer{}
+
+	delta, err := s.renderFrame(&frame, "templates/screen.tmpl.html", previous, snapshot)
 
 	if err != nil {
-		return errors.New("Could not render index template: " + err.Error())
+		return err
 	}
-	return nil
+
+	// A delta only holds out of band swaps, the screen itself stays
+	if delta {
+		w.Header().Set("HX-Reswap", "none")
+	}
+
+	game_state.polled.Store(snapshot)
+	s.frameDelivered(game_state, TransportPoll, frame.Len(), delta)
+
+	_, err = frame.WriteTo(w)
+
+	return err
 }
 func (s *ServerState) PlayerJumped(w http.ResponseWriter, r *http.Request) error {
 	game_state, err := s.GetSessionGameState(r)
@@ -164,10 +205,7 @@ func (s *ServerState) PlayerJumped(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 
-	game_state.Player.Started = true
-	game_state.Player.Jumping = true
-
-	s.SetSessionGameState(r, game_state)
+	game_state.Jump()
 
 	w.WriteHeader(200)
 
@@ -188,6 +226,85 @@ func (s *ServerState) InitializePlayerSession(w http.ResponseWriter, r *http.Req
 	return temp_session.Value, nil
 }
 
+// Creates the game state of a session with its course generated from seed
+// in the given mode
+func (s *ServerState) newSessionGameState(session_id string, seed int64, mode Mode) *GameState {
+	new_game_state := NewSeededGameState(mode.Gameplay(s.Config.Gameplay), seed)
+	new_game_state.Mode = mode
+	new_game_state.DebugAllowed = s.Config.Server.DebugOverlay
+	new_game_state.OnGameOver = func(result RunResult) {
+		previous_best := s.personalBest(session_id, new_game_state.UserID, result.Mode)
+		s.recordRun(session_id, new_game_state.UserID, result)
+
+		new_game_state.setPersonalBest(result.Run, max(previous_best, result.Score), result.Score > previous_best)
+	}
+
+	return new_game_state
+}
+
+// Swaps the session over to a fresh game state on the course of seed and
+// mode that keeps the session's settings. setup can prepare the new game state before
+// it is started, an error from it leaves the session untouched
+func (s *ServerState) replaceSessionGameState(r *http.Request, seed int64, mode Mode, setup func(*GameState) error) (*GameState, error) {
+	session_id, err := sessionID(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	old_game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	old_snapshot := old_game_state.Snapshot()
+
+	new_game_state := s.newSessionGameState(session_id, seed, mode)
+	new_game_state.Transport = old_snapshot.Transport
+	new_game_state.setTargetFPS(old_snapshot.TargetFPS)
+	new_game_state.UserID = old_snapshot.UserID
+	new_game_state.DebugMode = old_snapshot.DebugMode
+
+	if setup != nil {
+		err = setup(new_game_state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.leaveRoom(old_game_state)
+	old_game_state.Stop()
+	new_game_state.Start()
+	s.GameStates.Store(session_id, new_game_state)
+
+	return new_game_state, nil
+}
+
+// Creates and stores the game state of a session from the mode and
+// transport asked for in the request
+func (s *ServerState) startSessionGameState(r *http.Request, session_id string) *GameState {
+	mode, err := ModeFromKey(r.FormValue("mode"))
+
+	if err != nil {
+		mode = DefaultMode
+	}
+
+	seed, day := courseFor(mode, time.Now())
+
+	new_game_state := s.newSessionGameState(session_id, seed, mode)
+	new_game_state.Day = day
+	new_game_state.Transport = transportFromRequest(r)
+	new_game_state.UserID = UserIDFromContext(r.Context())
+	new_game_state.DebugMode = new_game_state.DebugAllowed && r.FormValue("debug") != ""
+
+	// The scheduler picks the session up on its next tick
+	new_game_state.Start()
+	s.GameStates.Store(session_id, new_game_state)
+
+	return new_game_state
+}
+
 func (s *ServerState) PlayerEntered(w http.ResponseWriter, r *http.Request) error {
 
 	temp_session_id, err := s.InitializePlayerSession(w, r)
@@ -196,13 +313,9 @@ func (s *ServerState) PlayerEntered(w http.ResponseWriter, r *http.Request) erro
 		return errors.New("Could not initalize user session")
 	}
 
-	new_game_state := NewGameState()
-
-	s.GameStates.Store(temp_session_id, new_game_state)
-
-	s.NewPhysicsSession(temp_session_id)
+	new_game_state := s.startSessionGameState(r, temp_session_id)
 
-	err = s.Templates.ExecuteTemplate(w, "templates/index.tmpl.html", new_game_state)
+	err = s.Templates.ExecuteTemplate(w, "templates/index.tmpl.html", new_game_state.Snapshot())
 	if err != nil {
 		return err
 	}
@@ -210,12 +323,20 @@ func (s *ServerState) PlayerEntered(w http.ResponseWriter, r *http.Request) erro
 	return nil
 }
 
+func sessionID(r *http.Request) (string, error) {
+	session, err := r.Cookie("session")
+
+	if err != nil {
+		return "", err
+	}
+
+	return session.Value, nil
+}
+
 func (s *ServerState) GetSessionGameState(r *http.Request) (*GameState, error) {
 	s.Mut.Lock()
 	defer s.Mut.Unlock()
-	session, err := r.Cookie("session")
-
-	session_id := session.Value
+	session_id, err := sessionID(r)
 
 	if err != nil {
 		return &GameState{}, err
@@ -227,48 +348,71 @@ func (s *ServerState) GetSessionGameState(r *http.Request) (*GameState, error) {
 	}
 
 	game_state := sync_state.(*GameState)
+	game_state.Touch()
 
 	return game_state, nil
 }
 
 func (s *ServerState) SetSessionGameState(r *http.Request, game_state *GameState) error {
 
-	session_id, err := r.Cookie("session")
+	session_id, err := sessionID(r)
 
 	if err != nil {
 		return err
 	}
 
-	s.GameStates.Store(session_id.Value, game_state)
+	s.GameStates.Store(session_id, game_state)
 
 	return nil
 }
 
-// func (s *ServerState) getUser(r *http.Request) (models.User, error) {
-//
-// }
-
 type Claims struct {
 	UserID string `json:"user_id"`
 	jwt.StandardClaims
 }
 
-func (s *ServerState) ParsePermJWT(tokenString string) (*Claims, error) {
-	claims := &Claims{}
+// Finds the secret a token was signed with from its key ID, tokens without
+// one are checked against the current secret
+func (s *ServerState) jwtSecretFor(token *jwt.Token) ([]byte, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("Unexpected signing method %v", token.Header["alg"])
+	}
+
+	key_id, _ := token.Header["kid"].(string)
+
+	if key_id == "" || key_id == jwtKeyID(s.JWTSecret) {
+		return []byte(s.JWTSecret), nil
+	}
+
+	for _, old_secret := range s.JWTOldSecrets {
+		if key_id == jwtKeyID(old_secret) {
+			return []byte(old_secret), nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown key id %s", key_id)
+}
+
+// Verifies a permanent token, stale reports whether it was signed with a
+// rotated out secret and should be reissued
+func (s *ServerState) ParsePermJWT(tokenString string) (claims *Claims, stale bool, err error) {
+	claims = &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return s.JWTSecret, nil
+		return s.jwtSecretFor(token)
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	if !token.Valid {
-		return nil, fmt.Errorf("Invalid token")
+		return nil, false, fmt.Errorf("Invalid token")
 	}
 
-	return claims, nil
+	key_id, _ := token.Header["kid"].(string)
+
+	return claims, key_id != jwtKeyID(s.JWTSecret), nil
 }
 
 func (s *ServerState) GeneratePermJWT(user_id string) (string, error) {
@@ -283,8 +427,9 @@ func (s *ServerState) GeneratePermJWT(user_id string) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = jwtKeyID(s.JWTSecret)
 
-	token_string, err := token.SignedString(s.JWTSecret)
+	token_string, err := token.SignedString([]byte(s.JWTSecret))
 
 	if err != nil {
 		return "", err
@@ -0,0 +1,117 @@
+package game
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deastl/flappybird-htmx/config"
+)
+
+// Polls /get-screen naming if_none_match as the frame the client shows
+func pollFrame(t *testing.T, s *ServerState, if_none_match string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodGet, "/get-screen", nil)
+	request.AddCookie(&http.Cookie{Name: "session", Value: "polling"})
+	if if_none_match != "" {
+		request.Header.Set("If-None-Match", if_none_match)
+	}
+	response := httptest.NewRecorder()
+
+	if err := s.PlayerRequestedFrame(response, request); err != nil {
+		t.Fatalf("get-screen: %v", err)
+	}
+
+	return response
+}
+
+// Steps a started session until it published a frame that looks different
+func advanceFrame(t *testing.T, game_state *GameState) {
+	t.Helper()
+
+	seq := game_state.Frame().Seq
+	for i := 0; i < 100 && game_state.Frame().Seq == seq; i++ {
+		advanced := make(chan struct{})
+		game_state.Advance(1, func() { close(advanced) })
+		<-advanced
+	}
+
+	if game_state.Frame().Seq == seq {
+		t.Fatal("frame never changed")
+	}
+}
+
+func TestPollConditionalGet(t *testing.T) {
+	s := newTestServerState(t)
+
+	game_state := NewSeededGameState(config.DefaultGameplay(), 1)
+	game_state.Start()
+	defer game_state.Stop()
+	s.GameStates.Store("polling", game_state)
+
+	response := pollFrame(t, s, "")
+	shown := response.Header().Get("ETag")
+
+	if response.Code != http.StatusOK || response.Header().Get("HX-Reswap") != "" {
+		t.Fatalf("first poll: %d, HX-Reswap %q, want a full frame", response.Code, response.Header().Get("HX-Reswap"))
+	}
+	if shown != frameETag(game_state.Frame().Seq) {
+		t.Errorf("ETag %s, want %s", shown, frameETag(game_state.Frame().Seq))
+	}
+
+	// Nothing moves before the first jump
+	response = pollFrame(t, s, shown)
+
+	if response.Code != http.StatusNotModified || response.Header().Get("HX-Reswap") != "none" {
+		t.Errorf("current frame: %d, HX-Reswap %q, want 304 and none", response.Code, response.Header().Get("HX-Reswap"))
+	}
+	if response.Body.Len() != 0 {
+		t.Errorf("304 with a body: %q", response.Body.String())
+	}
+
+	game_state.Jump()
+	advanceFrame(t, game_state)
+
+	response = pollFrame(t, s, shown)
+	etag := response.Header().Get("ETag")
+
+	if response.Code != http.StatusOK || response.Header().Get("HX-Reswap") != "none" {
+		t.Errorf("stale frame: %d, HX-Reswap %q, want a delta", response.Code, response.Header().Get("HX-Reswap"))
+	}
+	if etag == shown || etag != frameETag(game_state.Frame().Seq) {
+		t.Errorf("delta tagged %s, was %s, frame is %s", etag, shown, frameETag(game_state.Frame().Seq))
+	}
+	if !strings.Contains(response.Body.String(), `hx-swap-oob="innerHTML:#frame-player"`) {
+		t.Errorf("delta does not move the player: %q", response.Body.String())
+	}
+
+	// A client that lost track of its frame has to be sent all of it
+	advanceFrame(t, game_state)
+	response = pollFrame(t, s, `"0"`)
+
+	if response.Code != http.StatusOK || response.Header().Get("HX-Reswap") != "" {
+		t.Errorf("unknown frame: %d, HX-Reswap %q, want a full frame", response.Code, response.Header().Get("HX-Reswap"))
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	cases := []struct {
+		if_none_match string
+		want          bool
+	}{
+		{`"7"`, true},
+		{`W/"7"`, true},
+		{`"3", "7"`, true},
+		{`"70"`, false},
+		{`7`, false},
+		{``, false},
+	}
+
+	for _, c := range cases {
+		if got := etagMatches(c.if_none_match, 7); got != c.want {
+			t.Errorf("etagMatches(%q, 7) = %v, want %v", c.if_none_match, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package game
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/metrics"
+)
+
+// How often the session manager looks for sessions to evict
+const sessionSweepInterval = 10 * time.Second
+
+type SessionStats struct {
+	Active  int
+	Evicted int64 // Sessions evicted since the server started
+}
+
+// Tracks when each session was last seen and removes sessions that went
+// idle or finished, stopping their physics
+type SessionManager struct {
+	TTL         time.Duration
+	OnEvict     func(*GameState) // Called after a session has been stopped and removed
+	states      *sync.Map
+	evicted     atomic.Int64
+	last_frames sync.Map // Session ID to the evictedFrame of sessions that were evicted once stopped
+}
+
+// What a stopped session showed last, kept a TTL after its eviction so a
+// late dead screen request can still be answered
+type evictedFrame struct {
+	frame      *FrameSnapshot
+	evicted_at time.Time
+}
+
+func NewSessionManager(states *sync.Map, ttl time.Duration) *SessionManager {
+	return &SessionManager{
+		TTL:    ttl,
+		states: states,
+	}
+}
+
+// Evicts idle and stopped sessions every sweep interval, never returns
+func (m *SessionManager) Run() {
+	for {
+		time.Sleep(sessionSweepInterval)
+		m.EvictIdle(time.Now())
+	}
+}
+
+// Removes every session that was stopped or not seen within the TTL and
+// returns how many were removed
+func (m *SessionManager) EvictIdle(now time.Time) int {
+	evicted := 0
+
+	m.last_frames.Range(func(key any, value any) bool {
+		if now.Sub(value.(evictedFrame).evicted_at) >= m.TTL {
+			m.last_frames.Delete(key)
+		}
+		return true
+	})
+
+	m.states.Range(func(key any, value any) bool {
+		session_id := key.(string)
+		game_state := value.(*GameState)
+
+		idle := now.Sub(game_state.LastSeen())
+
+		if !game_state.Stopped() && idle < m.TTL {
+			return true
+		}
+
+		if game_state.Stopped() {
+			m.last_frames.Store(session_id, evictedFrame{game_state.Frame(), now})
+		}
+
+		game_state.Stop()
+		m.states.Delete(session_id)
+		evicted++
+
+		if m.OnEvict != nil {
+			m.OnEvict(game_state)
+		}
+
+		log.Printf("Evicted session %s after %s idle", session_id, idle.Round(time.Second))
+
+		return true
+	})
+
+	m.evicted.Add(int64(evicted))
+	metrics.SessionsEvicted.Add(float64(evicted))
+
+	return evicted
+}
+
+// The last frame of a session evicted after it stopped, nil when there is
+// none or it was evicted more than a TTL ago
+func (m *SessionManager) LastFrame(session_id string) *FrameSnapshot {
+	value, ok := m.last_frames.Load(session_id)
+
+	if !ok {
+		return nil
+	}
+
+	return value.(evictedFrame).frame
+}
+
+func (m *SessionManager) Stats() SessionStats {
+	active := 0
+	m.states.Range(func(key any, value any) bool {
+		active++
+		return true
+	})
+
+	return SessionStats{
+		Active:  active,
+		Evicted: m.evicted.Load(),
+	}
+}
@@ -0,0 +1,43 @@
+package game
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/config"
+)
+
+// Dead screen requests may come in after the stopped session was evicted
+func TestLastFrameOfEvictedSession(t *testing.T) {
+	states := sync.Map{}
+	manager := NewSessionManager(&states, time.Minute)
+
+	stopped := NewSeededGameState(config.DefaultGameplay(), 1)
+	stopped.Start()
+	stopped.Stop()
+	states.Store("stopped", stopped)
+
+	idle := NewSeededGameState(config.DefaultGameplay(), 2)
+	idle.Start()
+	states.Store("idle", idle)
+
+	now := time.Now().Add(2 * time.Minute)
+
+	if evicted := manager.EvictIdle(now); evicted != 2 {
+		t.Fatalf("evicted %d sessions, want 2", evicted)
+	}
+
+	if manager.LastFrame("stopped") != stopped.Frame() {
+		t.Error("the stopped session's last frame is gone")
+	}
+	if manager.LastFrame("idle") != nil {
+		t.Error("kept the frame of a session the player left")
+	}
+
+	manager.EvictIdle(now.Add(time.Minute))
+
+	if manager.LastFrame("stopped") != nil {
+		t.Error("last frame kept past the TTL")
+	}
+}
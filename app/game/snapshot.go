@@ -0,0 +1,129 @@
+package game
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Hands out the seq of every frame that looks new, across all sessions so a
+// frame's seq also tells which session it is from
+var frame_seqs atomic.Int64
+
+// What one frame of a session shows, built by its owner once per tick and
+// shared by every renderer and transport until the next one. Nothing in it
+// is shared with the game state, so it must not be modified
+type FrameSnapshot struct {
+	Seq                    int // Only changes when the frame looks different, 0 for frames that weren't published
+	Tick                   int
+	Player                 Player
+	Pipes                  []PipeSet // In creation order
+	Points                 int
+	BackgroundOffset       int
+	BackgroundGroundOffset int
+	Ghosts                 []Ghost
+	Countdown              int // Seconds left of the room countdown, 0 when there is none
+	InRoom                 bool
+	FPS                    int
+	TargetFPS              int
+	Transport              string
+	DebugMode              bool
+	TickDuration           time.Duration
+	StepDuration           time.Duration
+	ReplayID               string
+	Mode                   Mode
+	PersonalBest           int
+	NewBest                bool
+	DeadScreen             bool // The last frame of the run, the dead screen replaces it
+}
+
+// Whether the player has started and is still alive
+func (f *FrameSnapshot) Running() bool {
+	return f.Player.Started && !f.Player.Dead
+}
+
+// Copy of everything the page templates show of a session, taken by its
+// owner on request. Frames only need the FrameSnapshot it embeds
+type Snapshot struct {
+	*FrameSnapshot
+	RacingBest      bool
+	TotalFrameCount int
+	PollRate        string
+	TransportStats  map[string]TransportStats // Transports that delivered frames
+	DebugAllowed    bool
+	Day             string
+	UserID          string
+}
+
+func (s *GameState) frameSnapshot() *FrameSnapshot {
+	pipes := make([]PipeSet, 0, len(s.pipe_order))
+	for _, id := range s.pipe_order {
+		pipes = append(pipes, *s.Pipes[id])
+	}
+
+	return &FrameSnapshot{
+		Tick:                   s.Tick,
+		Player:                 s.Player,
+		Pipes:                  pipes,
+		Points:                 s.Points,
+		BackgroundOffset:       s.BackgroundOffset,
+		BackgroundGroundOffset: s.BackgroundGroundOffset,
+		Ghosts:                 s.Ghosts(),
+		Countdown:              s.Countdown(),
+		InRoom:                 s.Room != nil,
+		FPS:                    s.FPS,
+		TargetFPS:              s.TargetFPS,
+		Transport:              s.Transport,
+		DebugMode:              s.DebugMode,
+		TickDuration:           s.gameplay.TickDuration,
+		StepDuration:           s.StepDuration,
+		ReplayID:               s.ReplayID,
+		Mode:                   s.Mode,
+		PersonalBest:           s.PersonalBest,
+		NewBest:                s.NewBest,
+		DeadScreen:             s.dead_screen,
+	}
+}
+
+// Makes the current state the frame handlers render, only the owner may
+// call it
+func (s *GameState) publishFrame() {
+	frame := s.frameSnapshot()
+
+	previous := s.frame.Load()
+	if previous != nil && sameFrame(previous, frame) {
+		frame.Seq = previous.Seq
+	} else {
+		frame.Seq = int(frame_seqs.Add(1))
+	}
+
+	s.frame.Store(frame)
+}
+
+func (s *GameState) snapshot() *Snapshot {
+	transport_stats := map[string]TransportStats{}
+	for transport, counters := range s.transport_counters {
+		frames := int(counters.frames.Load())
+		unchanged := int(counters.unchanged.Load())
+		if frames+unchanged == 0 {
+			continue
+		}
+		transport_stats[transport] = TransportStats{
+			Frames:     frames,
+			Bytes:      int(counters.bytes.Load()),
+			Deltas:     int(counters.deltas.Load()),
+			DeltaBytes: int(counters.delta_bytes.Load()),
+			Unchanged:  unchanged,
+		}
+	}
+
+	return &Snapshot{
+		FrameSnapshot:   s.frameSnapshot(),
+		RacingBest:      s.BestRun != nil,
+		TotalFrameCount: s.TotalFrameCount,
+		PollRate:        s.PollRate,
+		TransportStats:  transport_stats,
+		DebugAllowed:    s.DebugAllowed,
+		Day:             s.Day,
+		UserID:          s.UserID,
+	}
+}
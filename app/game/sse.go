@@ -0,0 +1,162 @@
+package game
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Writes a rendered fragment as a server sent event, every line of the
+// fragment needs its own data field
+func writeEvent(w *bytes.Buffer, event string, fragment string) {
+	w.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(fragment, "\n") {
+		w.WriteString("data: " + line + "\n")
+	}
+	w.WriteString("\n")
+}
+
+// Renders the screen, or its changes since previous, as out of band swaps
+// inside a frame event. Reports whether it rendered a delta
+func (s *ServerState) renderScreenEvent(event *bytes.Buffer, previous *FrameSnapshot, snapshot *FrameSnapshot) (bool, error) {
+	frame := bytes.Buffer{}
+
+	delta, err := s.renderFrame(&frame, "templates/screen-oob.tmpl.html", previous, snapshot)
+
+	if err != nil {
+		return false, err
+	}
+
+	event.Reset()
+	writeEvent(event, "frame", frame.String())
+
+	return delta, nil
+}
+
+// Ends an event stream with a frame event that swaps out the element that
+// connected it, the sse extension reconnects to any stream that closes while
+// that element is still on the page. A session that died ends on its dead
+// screen, anything else on its last screen
+func (s *ServerState) endEventStream(w http.ResponseWriter, flusher http.Flusher, snapshot *FrameSnapshot) error {
+	content := bytes.Buffer{}
+
+	err := s.Templates.ExecuteTemplate(&content, "templates/stream-end.tmpl.html", snapshot)
+
+	if err != nil {
+		return err
+	}
+
+	event := bytes.Buffer{}
+	writeEvent(&event, "frame", content.String())
+
+	_, err = event.WriteTo(w)
+
+	if err != nil {
+		return nil
+	}
+	flusher.Flush()
+
+	return nil
+}
+
+// Sets the headers of an event stream and sends them right away
+func startEventStream(w http.ResponseWriter, flusher http.Flusher) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	flusher.Flush()
+}
+
+// Streams out of band screen swaps after each physics tick, limited to the
+// session's target FPS, until the dead screen is due or the client goes away.
+// A finished session, even one evicted since, only gets its end of stream
+func (s *ServerState) PlayerConnectedStream(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	var final_frame *FrameSnapshot
+	if err != nil {
+		session_id, id_err := sessionID(r)
+		if id_err == nil {
+			final_frame = s.Sessions.LastFrame(session_id)
+		}
+		if final_frame == nil {
+			return errors.New("Error in sse: " + err.Error())
+		}
+	} else if game_state.Stopped() {
+		final_frame = game_state.Frame()
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("Streaming is not supported by the response writer")
+	}
+
+	startEventStream(w, flusher)
+
+	if final_frame != nil {
+		return s.endEventStream(w, flusher, final_frame)
+	}
+
+	ticks, stop_listening := game_state.ListenTicks()
+	defer stop_listening()
+
+	event := bytes.Buffer{}
+	var delivered *FrameSnapshot
+	last_frame := time.Time{}
+	target_fps := game_state.Frame().TargetFPS
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-game_state.Done():
+			return s.endEventStream(w, flusher, game_state.Frame())
+		case <-ticks:
+		}
+
+		game_state.Touch()
+
+		// Ticks don't line up with the frame delay, so allow up to half a
+		// tick early rather than skipping to the next one
+		frame_delay := time.Second / time.Duration(target_fps)
+		if time.Since(last_frame) < frame_delay-s.Config.Gameplay.TickDuration/2 {
+			continue
+		}
+		last_frame = time.Now()
+
+		snapshot, show_dead_screen := s.frameRequested(game_state)
+		target_fps = snapshot.TargetFPS
+
+		// The client already shows this frame
+		if delivered != nil && snapshot.Seq == delivered.Seq {
+			game_state.countUnchangedFrame(TransportSSE)
+			if show_dead_screen {
+				return s.endEventStream(w, flusher, snapshot)
+			}
+			continue
+		}
+
+		delta, err := s.renderScreenEvent(&event, delivered, snapshot)
+
+		if err != nil {
+			return err
+		}
+
+		s.frameDelivered(game_state, TransportSSE, event.Len(), delta)
+		delivered = snapshot
+
+		_, err = event.WriteTo(w)
+
+		if err != nil {
+			return nil
+		}
+		flusher.Flush()
+
+		if show_dead_screen {
+			return s.endEventStream(w, flusher, snapshot)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package game
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/config"
+)
+
+// A server state with the templates and sessions the handlers need, without
+// the background goroutines and metrics New starts
+func newTestServerState(t *testing.T) *ServerState {
+	t.Helper()
+
+	s := &ServerState{Config: config.Default()}
+	s.Config.Server.TemplateDir = "../templates"
+	s.Templates = template.New("")
+	s.Sessions = NewSessionManager(&s.GameStates, time.Minute)
+	s.initTempaltes()
+
+	return s
+}
+
+// The sse extension reconnects to any stream that closes while its element is
+// on the page, so a finished session's stream has to swap that element out
+func TestStreamOfStoppedSessionEnds(t *testing.T) {
+	s := newTestServerState(t)
+
+	game_state := NewSeededGameState(config.DefaultGameplay(), 1)
+	game_state.Start()
+	game_state.Stop()
+	s.GameStates.Store("stopped", game_state)
+
+	request := httptest.NewRequest(http.MethodGet, "/sse", nil)
+	request.AddCookie(&http.Cookie{Name: "session", Value: "stopped"})
+	response := httptest.NewRecorder()
+
+	if err := s.PlayerConnectedStream(response, request); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", response.Code)
+	}
+
+	body := response.Body.String()
+	if !strings.HasPrefix(body, "event: frame\n") {
+		t.Errorf("stream did not end on a frame event: %q", body)
+	}
+	if !strings.Contains(body, `<span id="screen-stream" hx-swap-oob="true">`) {
+		t.Errorf("stream element is not swapped out: %q", body)
+	}
+	if strings.Contains(body, "sse-connect") {
+		t.Errorf("final event connects a stream again: %q", body)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/sse", nil)
+	request.AddCookie(&http.Cookie{Name: "session", Value: "unknown"})
+
+	if err := s.PlayerConnectedStream(httptest.NewRecorder(), request); err == nil {
+		t.Error("streamed a session that never existed")
+	}
+}
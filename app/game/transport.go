@@ -0,0 +1,40 @@
+package game
+
+import "net/http"
+
+const (
+	TransportPoll = "poll" // Client polls /get-screen every PollRate
+	TransportSSE  = "sse"  // Server streams frames over /sse after physics ticks
+	TransportWS   = "ws"   // Server pushes frames over /ws
+)
+
+var Transports = []string{TransportPoll, TransportSSE, TransportWS}
+
+// Validates a transport name, falling back to polling
+func transportFromName(name string) string {
+	for _, transport := range Transports {
+		if transport == name {
+			return transport
+		}
+	}
+	return TransportPoll
+}
+
+// Picks the frame transport requested with the transport parameter
+func transportFromRequest(r *http.Request) string {
+	return transportFromName(r.FormValue("transport"))
+}
+
+// Switches the session to the requested transport and renders the
+// matching screen frame, so transports can be compared on one game state
+func (s *ServerState) PlayerChangedTransport(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return err
+	}
+
+	snapshot := game_state.SetTransport(transportFromRequest(r))
+
+	return s.Templates.ExecuteTemplate(w, "templates/screen-frame.tmpl.html", snapshot)
+}
@@ -0,0 +1,32 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/deastl/flappybird-htmx/config"
+)
+
+var ErrScoreMismatch = errors.New("score does not match replay")
+
+// Re-simulates a run without rendering and returns the points it scores
+func SimulateRun(gameplay config.Gameplay, seed int64, jump_ticks []int) int {
+	replay := NewReplay(gameplay, seed, jump_ticks)
+
+	for replay.Step() {
+	}
+
+	return replay.GameState.Points
+}
+
+// Checks a claimed score against the score recomputed from the seed and jump
+// ticks, returns the recomputed score and ErrScoreMismatch if they differ
+func VerifyRun(gameplay config.Gameplay, seed int64, jump_ticks []int, claimed_points int) (int, error) {
+	points := SimulateRun(gameplay, seed, jump_ticks)
+
+	if points != claimed_points {
+		return points, fmt.Errorf("%w: claimed %d, replay scored %d", ErrScoreMismatch, claimed_points, points)
+	}
+
+	return points, nil
+}
@@ -0,0 +1,31 @@
+package game
+
+import (
+	"errors"
+	"testing"
+)
+
+// Runs played live have to pass verification, anything tampered with must not
+func TestVerifyRun(t *testing.T) {
+	for _, mode := range Modes() {
+		t.Run(mode.Key(), func(t *testing.T) {
+			result, _ := playLive(t, mode, 7)
+
+			points, err := VerifyRun(result.Gameplay, result.Seed, result.JumpTicks, result.Score)
+			if err != nil || points != result.Score {
+				t.Errorf("live run of %d points rejected: %d, %v", result.Score, points, err)
+			}
+
+			_, err = VerifyRun(result.Gameplay, result.Seed, result.JumpTicks, result.Score+1)
+			if !errors.Is(err, ErrScoreMismatch) {
+				t.Errorf("inflated score accepted: %v", err)
+			}
+
+			// Without the later jumps the player falls before the first pipe
+			_, err = VerifyRun(result.Gameplay, result.Seed, result.JumpTicks[:1], result.Score)
+			if !errors.Is(err, ErrScoreMismatch) {
+				t.Errorf("score accepted with only the first jump: %v", err)
+			}
+		})
+	}
+}
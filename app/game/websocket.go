@@ -0,0 +1,111 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// Message sent by the htmx ws extension, the form values are flattened into it
+type socketMessage struct {
+	Action string `json:"action"`
+}
+
+func (s *ServerState) readSocketInputs(conn *websocket.Conn, game_state *GameState, closed chan struct{}) {
+	defer close(closed)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		message := socketMessage{}
+		err = json.Unmarshal(data, &message)
+		if err != nil {
+			log.Printf("Invalid socket message: %v", err)
+			continue
+		}
+
+		if message.Action == "jump" {
+			game_state.Jump()
+		}
+	}
+}
+
+// Pushes screen fragments at the session's target FPS and takes jump inputs
+// from the same socket until the dead screen is due or the client goes away
+func (s *ServerState) PlayerConnectedSocket(w http.ResponseWriter, r *http.Request) error {
+	game_state, err := s.GetSessionGameState(r)
+
+	if err != nil {
+		return errors.New("Error in ws: " + err.Error())
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		return errors.New("Could not upgrade connection: " + err.Error())
+	}
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	go s.readSocketInputs(conn, game_state, closed)
+
+	frame := bytes.Buffer{}
+	var delivered *FrameSnapshot
+	target_fps := game_state.Frame().TargetFPS
+
+	for {
+		frame_delay := time.Second / time.Duration(target_fps)
+
+		select {
+		case <-closed:
+			return nil
+		case <-game_state.Done():
+			// Stopped sessions still send their last frame and close normally,
+			// the ws extension would reconnect after an abnormal close
+		case <-time.After(frame_delay):
+		}
+
+		game_state.Touch()
+
+		snapshot, show_dead_screen := s.frameRequested(game_state)
+		target_fps = snapshot.TargetFPS
+
+		// The client already shows this frame
+		if delivered != nil && snapshot.Seq == delivered.Seq {
+			game_state.countUnchangedFrame(TransportWS)
+		} else {
+			frame.Reset()
+			delta, err := s.renderFrame(&frame, "templates/screen-oob.tmpl.html", delivered, snapshot)
+
+			if err != nil {
+				return err
+			}
+
+			s.frameDelivered(game_state, TransportWS, frame.Len(), delta)
+			delivered = snapshot
+
+			err = conn.WriteMessage(websocket.TextMessage, frame.Bytes())
+
+			if err != nil {
+				return nil
+			}
+		}
+
+		if show_dead_screen {
+			conn.WriteMessage(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "player died"),
+			)
+			return nil
+		}
+	}
+}
@@ -4,23 +4,45 @@ import (
 	//"compress/flate"
 	"compress/flate"
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/deastl/flappybird-htmx/config"
 	"github.com/deastl/flappybird-htmx/db"
 	"github.com/deastl/flappybird-htmx/game"
 	mid "github.com/deastl/flappybird-htmx/middlware"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
 
-	server_state := game.ServerState{}
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1:])
+		return
+	}
+
+	server_config, err := config.Load(os.Args[1:])
+
+	if err != nil {
+		log.Fatalf("Invalid config : %v", err)
+	}
+
+	server_state := game.ServerState{Config: server_config}
+
+	db_config, err := db.ParseDatabaseURL(server_config.Server.DatabaseURL)
+
+	if err != nil {
+		log.Fatalf("Invalid database_url : %v", err)
+	}
 
-	dbq, err := db.NewConnection()
+	connection, err := db.NewConnection(db_config)
 
 	if err != nil {
 		log.Fatalf("Could not open database connection : %v", err)
@@ -28,7 +50,8 @@ func main() {
 
 	ctx := context.Background()
 
-	server_state.Dbq = dbq
+	server_state.Db = connection
+	server_state.Dbq = connection.Queries
 	server_state.Ctx = ctx
 
 	server_state.New()
@@ -38,11 +61,12 @@ func main() {
 	r := chi.NewRouter()
 
 	compressor := middleware.NewCompressor(flate.BestSpeed)
+	r.Use(mid.RecordRequestMetrics)
 	r.Use(middleware.Recoverer)
 	r.Use(compressor.Handler)
 	r.Use(mid.InitializeUserSession(&server_state))
 
-	file_server := http.FileServer(http.Dir("./local/"))
+	file_server := http.FileServer(http.Dir(server_config.Server.StaticDir))
 	r.Handle("/local/*", http.StripPrefix("/local", file_server))
 
 	// Report info to log
@@ -63,6 +87,8 @@ func main() {
 		}
 	})
 
+	r.Handle("/metrics", promhttp.Handler())
+
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("good"))
 	})
@@ -75,14 +101,17 @@ func main() {
 			return
 		}
 
-		target_fps_str := r.URL.Query().Get("value")
+		target_fps_str := r.FormValue("value")
 		target_fps, err := strconv.ParseInt(target_fps_str, 10, 64)
 
+		if err != nil {
+			http.Error(w, "Invalid target fps: "+target_fps_str, 400)
+			return
+		}
+
 		game_state.SetTargetFPS(int(target_fps))
 
-		game_state.Mut.Lock()
-		err = server_state.Templates.ExecuteTemplate(w, "templates/screen-frame.tmpl.html", game_state)
-		game_state.Mut.Unlock()
+		err = server_state.Templates.ExecuteTemplate(w, "templates/screen-frame.tmpl.html", game_state.Snapshot())
 		if err != nil {
 			http.Error(w, "Error running screen-frame template", 500)
 			return
@@ -99,15 +128,63 @@ func main() {
 
 	})
 
+	r.Get("/ws", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.PlayerConnectedSocket(w, r)
+
+		if err != nil {
+			log.Printf("Error in ws: %v", err)
+		}
+	})
+
+	r.Get("/sse", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.PlayerConnectedStream(w, r)
+
+		if err != nil {
+			http.Error(w, "Error in sse: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Post("/update-transport", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.PlayerChangedTransport(w, r)
+
+		if err != nil {
+			http.Error(w, "Error in update-transport: "+err.Error(), 500)
+			return
+		}
+	})
+
 	r.Get("/get-dead-screen", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		err := server_state.Templates.ExecuteTemplate(w, "dead-screen.tmpl.html", []byte{})
+		err := server_state.DeadScreenRequested(w, r)
 
 		if err != nil {
 			http.Error(w, "Error in get-dead-screen: "+err.Error(), 500)
 		}
 	})
 
+	r.Post("/debug", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.PlayerToggledDebug(w, r)
+
+		if errors.Is(err, game.ErrDebugDisabled) {
+			http.Error(w, err.Error(), 403)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "Error in debug: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Post("/restart", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.PlayerRestarted(w, r)
+
+		if err != nil {
+			http.Error(w, "Error in restart: "+err.Error(), 500)
+			return
+		}
+	})
+
 	r.Get("/empty", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(200)
@@ -123,6 +200,152 @@ func main() {
 		}
 	})
 
+	r.Get("/leaderboard", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.LeaderboardRequested(w, r)
+
+		if err != nil {
+			http.Error(w, "Error in leaderboard: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Get("/lobby", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.LobbyRequested(w, r)
+
+		if err != nil {
+			http.Error(w, "Error in lobby: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Post("/room/create", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.RoomCreated(w, r)
+
+		if err != nil {
+			http.Error(w, "Error creating room: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Post("/room/join", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.RoomJoined(w, r)
+
+		if err != nil {
+			http.Error(w, "Error joining room: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Post("/room/start", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.RoomStarted(w, r)
+
+		if err != nil {
+			http.Error(w, "Error starting room: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Get("/mode", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.ModePickerRequested(w, r)
+
+		if err != nil {
+			http.Error(w, "Error in mode: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Post("/mode", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.PlayerChangedMode(w, r)
+
+		if errors.Is(err, game.ErrUnknownMode) {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "Error changing mode: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Get("/ghost", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.GhostToggleRequested(w, r)
+
+		if err != nil {
+			http.Error(w, "Error in ghost: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Post("/ghost", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.GhostToggled(w, r)
+
+		if err != nil {
+			http.Error(w, "Error toggling ghost: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Get("/name", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.NameFormRequested(w, r)
+
+		if err != nil {
+			http.Error(w, "Error in name: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Post("/name", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.NameClaimed(w, r)
+
+		if err != nil {
+			http.Error(w, "Error claiming name: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Get("/u/{name}", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.ProfileRequested(w, r, chi.URLParam(r, "name"))
+
+		if errors.Is(err, game.ErrProfileNotFound) {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "Error in profile: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Get("/replay/{id}", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.ReplayRequested(w, r, chi.URLParam(r, "id"))
+
+		if errors.Is(err, game.ErrReplayNotFound) {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "Error in replay: "+err.Error(), 500)
+			return
+		}
+	})
+
+	r.Get("/replay/{id}/stream", func(w http.ResponseWriter, r *http.Request) {
+		err := server_state.ReplayRequestedStream(w, r, chi.URLParam(r, "id"))
+
+		if errors.Is(err, game.ErrReplayNotFound) {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "Error in replay stream: "+err.Error(), 500)
+			return
+		}
+	})
+
 	r.Get("/get-stats", func(w http.ResponseWriter, r *http.Request) {
 
 		game_state, err := server_state.GetSessionGameState(r)
@@ -131,9 +354,7 @@ func main() {
 			return
 		}
 
-		game_state.Mut.Lock()
-		err = server_state.Templates.ExecuteTemplate(w, "templates/stats.tmpl.html", game_state)
-		game_state.Mut.Unlock()
+		err = server_state.Templates.ExecuteTemplate(w, "templates/stats.tmpl.html", game_state.Snapshot())
 
 		if err != nil {
 			http.Error(w, "Error running template in get-stats: "+err.Error(), 500)
@@ -141,7 +362,8 @@ func main() {
 		}
 	})
 
-	err = http.ListenAndServe("0.0.0.0:3200", r)
+	log.Printf("Listening on %s", server_config.Server.Addr)
+	err = http.ListenAndServe(server_config.Server.Addr, r)
 
 	if err != nil {
 		log.Printf("Error starting server: %v", err)
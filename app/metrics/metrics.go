@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flappybird_http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests by route.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"route", "method", "status"})
+
+	StreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flappybird_stream_duration_seconds",
+		Help:    "How long streaming connections stayed open by route, kept out of the request latencies.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"route"})
+
+	AchievedFPS = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "flappybird_achieved_fps",
+		Help:    "Frames per second delivered to a session, sampled every few seconds.",
+		Buckets: prometheus.LinearBuckets(0, 5, 7),
+	})
+
+	TargetFPS = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "flappybird_target_fps",
+		Help:    "Target frames per second of a session, sampled with flappybird_achieved_fps.",
+		Buckets: prometheus.LinearBuckets(0, 5, 7),
+	})
+
+	TickDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "flappybird_tick_duration_seconds",
+		Help:    "Time taken to step every live session once.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 12),
+	})
+
+	TickOverruns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "flappybird_tick_overruns_total",
+		Help: "Ticks that took longer than the tick duration.",
+	})
+
+	Deaths = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "flappybird_deaths_total",
+		Help: "Runs that ended with the player dying.",
+	})
+
+	Scores = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "flappybird_score",
+		Help:    "Score of finished runs.",
+		Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100, 200},
+	})
+
+	SessionsEvicted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "flappybird_sessions_evicted_total",
+		Help: "Sessions removed for being idle or finished.",
+	})
+)
+
+// Where the gauges below read from, set by whoever serves the sessions. The
+// collectors are registered once, so any number of servers can be built in
+// one process and the last one built is reported
+var (
+	active_sessions atomic.Pointer[func() float64]
+	frames_served   atomic.Pointer[func() float64]
+)
+
+func read(source *atomic.Pointer[func() float64]) float64 {
+	value := source.Load()
+	if value == nil {
+		return 0
+	}
+	return (*value)()
+}
+
+var (
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "flappybird_active_sessions",
+		Help: "Sessions currently held by the server.",
+	}, func() float64 { return read(&active_sessions) })
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "flappybird_frames_served_total",
+		Help: "Screen frames rendered for clients over any transport.",
+	}, func() float64 { return read(&frames_served) })
+)
+
+// Exposes the live session count, read when Prometheus scrapes
+func ObserveActiveSessions(active func() float64) {
+	active_sessions.Store(&active)
+}
+
+// Exposes the total of frames served, read when Prometheus scrapes
+func ObserveFramesServed(frames func() float64) {
+	frames_served.Store(&frames)
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Every server built in a process sets the sources again, which must not
+// register the collectors twice
+func TestObserveTwice(t *testing.T) {
+	ObserveActiveSessions(func() float64 { return 1 })
+	ObserveFramesServed(func() float64 { return 10 })
+	ObserveActiveSessions(func() float64 { return 2 })
+	ObserveFramesServed(func() float64 { return 20 })
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if metric.GetGauge() != nil {
+				values[family.GetName()] = metric.GetGauge().GetValue()
+			}
+			if metric.GetCounter() != nil {
+				values[family.GetName()] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	if values["flappybird_active_sessions"] != 2 {
+		t.Errorf("active sessions = %v, want 2", values["flappybird_active_sessions"])
+	}
+	if values["flappybird_frames_served_total"] != 20 {
+		t.Errorf("frames served = %v, want 20", values["flappybird_frames_served_total"])
+	}
+}
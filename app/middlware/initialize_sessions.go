@@ -1,40 +1,95 @@
 package middlware
 
 import (
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/deastl/flappybird-htmx/game"
+	"github.com/deastl/flappybird-htmx/models"
+	"github.com/deastl/flappybird-htmx/services"
 )
 
+// Paths that never need a player identity
+var untrackedPrefixes = []string{"/local/", "/health", "/metrics"}
+
+// Issues a perm_jwt cookie to a new permanent user
+func issuePermToken(w http.ResponseWriter, server_state *game.ServerState, user_id string) {
+	perm_token, err := server_state.GeneratePermJWT(user_id)
+
+	if err != nil {
+		log.Printf("Error generating JWT token %s : %v", user_id, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "perm_jwt",
+		Value:    perm_token,
+		Path:     "/",
+		MaxAge:   10 * 365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Resolves the permanent user behind the perm_jwt cookie, creating a user and
+// cookie for players entering the game without one, and attaches the user ID
+// to the request context
 func InitializeUserSession(server_state *game.ServerState) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, prefix := range untrackedPrefixes {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			user_id := ""
+
+			perm_session, err := r.Cookie("perm_jwt")
+
+			if err == nil && perm_session.Value != "" {
+				claims, stale, err := server_state.ParsePermJWT(perm_session.Value)
+
+				if err != nil {
+					log.Printf("Rejected perm_jwt : %v", err)
+				} else {
+					user_id = claims.UserID
+					if stale {
+						issuePermToken(w, server_state, user_id)
+					}
+					// The token may come from another node or from before a
+					// database reset, entering the game brings its user back
+					if r.URL.Path == "/" {
+						err = services.UserRestore(server_state.Ctx, server_state.Dbq, user_id)
+						if err != nil {
+							log.Printf("Error restoring user %s : %v", user_id, err)
+						}
+					}
+				}
+			}
+
+			// Only entering the game creates a user, so crawlers and probes
+			// hitting other pages don't fill the users table
+			if user_id == "" && r.URL.Path == "/" {
+				new_user := models.User{
+					Name: "",
+				}
+				err = services.UserCreate(server_state.Ctx, server_state.Dbq, &new_user)
+
+				if err != nil {
+					log.Printf("Error creating user %s : %v", new_user.ID, err)
+				} else {
+					user_id = new_user.ID
+					issuePermToken(w, server_state, user_id)
+				}
+			}
+
+			if user_id != "" {
+				r = r.WithContext(game.WithUserID(r.Context(), user_id))
+			}
 
-			// 			perm_session, err := r.Cookie("perm_jwt")
-			//
-			// 			if perm_session == nil || perm_session.Value == "" {
-			// 				err = nil
-			// 				new_user := models.User{
-			// 					Name: "",
-			// 				}
-			// 				err = services.UserCreate(server_state.Ctx, server_state.Dbq, &new_user)
-			//
-			// 				if err != nil {
-			// 					log.Printf("Error creating user %s : %v", new_user.ID, err)
-			// 				}
-			// 				perm_token, err := server_state.GeneratePermJWT(new_user.ID)
-			//
-			// 				if err != nil {
-			// 					log.Printf("Error generating JWT token %s : %v", new_user.ID, err)
-			// 				}
-			//
-			// 				perm_session = &http.Cookie{
-			// 					Name:  "perm_jwt",
-			// 					Value: perm_token,
-			// 				}
-			// 				http.SetCookie(w, perm_session)
-			// 			}
-			//
 			next.ServeHTTP(w, r)
 
 		})
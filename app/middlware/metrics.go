@@ -0,0 +1,47 @@
+package middlware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/metrics"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Routes that hold the connection open for as long as the client watches,
+// their durations would swamp the request latencies
+var streamRoutes = []string{"/ws", "/sse", "/replay/{id}/stream"}
+
+// Records the latency of every request labelled with its route pattern, and
+// how long streams stayed open separately
+func RecordRequestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		wrapped_writer := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(wrapped_writer, r)
+
+		route := "unmatched"
+		route_context := chi.RouteContext(r.Context())
+		if route_context != nil && route_context.RoutePattern() != "" {
+			route = route_context.RoutePattern()
+		}
+
+		if slices.Contains(streamRoutes, route) {
+			metrics.StreamDuration.WithLabelValues(route).Observe(time.Since(started).Seconds())
+			return
+		}
+
+		status := wrapped_writer.Status()
+		if status == 0 {
+			status = 200
+		}
+
+		metrics.RequestDuration.
+			WithLabelValues(route, r.Method, strconv.Itoa(status)).
+			Observe(time.Since(started).Seconds())
+	})
+}
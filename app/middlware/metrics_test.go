@@ -0,0 +1,62 @@
+package middlware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Samples recorded per route label of the histogram called name
+func routeSamples(t *testing.T, name string) map[string]uint64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	samples := map[string]uint64{}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "route" {
+					samples[label.GetValue()] += metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+
+	return samples
+}
+
+func TestStreamsKeptOutOfRequestLatency(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(RecordRequestMetrics)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.Get("/sse", ok)
+	router.Get("/replay/{id}/stream", ok)
+	router.Get("/get-screen", ok)
+
+	for _, path := range []string{"/sse", "/replay/run/stream", "/get-screen"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	requests := routeSamples(t, "flappybird_http_request_duration_seconds")
+	streams := routeSamples(t, "flappybird_stream_duration_seconds")
+
+	if requests["/get-screen"] != 1 || streams["/get-screen"] != 0 {
+		t.Errorf("get-screen recorded as %d requests and %d streams", requests["/get-screen"], streams["/get-screen"])
+	}
+
+	for _, route := range []string{"/sse", "/replay/{id}/stream"} {
+		if requests[route] != 0 || streams[route] != 1 {
+			t.Errorf("%s recorded as %d requests and %d streams", route, requests[route], streams[route])
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"github.com/deastl/flappybird-htmx/config"
+)
+
+type Replay struct {
+	RunID     string
+	Seed      int64
+	JumpTicks []int // Simulation ticks the player jumped on
+	CreatedAt time.Time
+	Gameplay  *config.Gameplay // Settings the run was simulated with, nil for replays recorded before they were stored
+}
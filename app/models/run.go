@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+type Run struct {
+	ID        string
+	UserID    string
+	UserName  string // Only set on leaderboard runs, empty for players without a name
+	SessionID string
+	Score     int
+	Duration  time.Duration
+	Frames    int
+	Mode      string // Difficulty mode the run was played in, runs only compete within a mode
+	Daily     string // Day of the daily challenge the run was played on, empty otherwise
+	Counted   bool   // False for daily attempts that don't count towards the daily leaderboard
+	CreatedAt time.Time
+}
+
+// A stored run together with the inputs needed to re-simulate it
+type RunReplay struct {
+	Run    Run
+	Replay Replay
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/config"
+	"github.com/deastl/flappybird-htmx/db"
+	"github.com/deastl/flappybird-htmx/models"
+)
+
+func replayFromDb(db_replay *db.Replay, model_replay *models.Replay) error {
+	created_at, err := time.Parse(time.RFC3339, db_replay.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal([]byte(db_replay.JumpTicks), &model_replay.JumpTicks)
+	if err != nil {
+		return err
+	}
+
+	model_replay.Gameplay = nil
+	if db_replay.Gameplay != "" {
+		gameplay := config.Gameplay{}
+		err = json.Unmarshal([]byte(db_replay.Gameplay), &gameplay)
+		if err != nil {
+			return err
+		}
+		model_replay.Gameplay = &gameplay
+	}
+
+	model_replay.RunID = db_replay.RunID
+	model_replay.Seed = db_replay.Seed
+	model_replay.CreatedAt = created_at
+
+	return nil
+}
+
+func ReplayCreate(ctx context.Context, q *db.Queries, new_replay *models.Replay) error {
+	jump_ticks, err := json.Marshal(new_replay.JumpTicks)
+	if err != nil {
+		return err
+	}
+
+	gameplay := []byte{}
+	if new_replay.Gameplay != nil {
+		gameplay, err = json.Marshal(new_replay.Gameplay)
+		if err != nil {
+			return err
+		}
+	}
+
+	new_replay.CreatedAt = time.Now().UTC()
+
+	return q.CreateReplay(ctx, db.CreateReplayParams{
+		RunID:     new_replay.RunID,
+		Seed:      new_replay.Seed,
+		JumpTicks: string(jump_ticks),
+		CreatedAt: new_replay.CreatedAt.Format(time.RFC3339),
+		Gameplay:  string(gameplay),
+	})
+}
+
+func ReplayGetByRunID(ctx context.Context, q *db.Queries, run_id string) (models.Replay, error) {
+	db_replay, err := q.GetReplayByRunID(ctx, run_id)
+	if err != nil {
+		return models.Replay{}, err
+	}
+
+	model_replay := models.Replay{}
+	err = replayFromDb(&db_replay, &model_replay)
+
+	if err != nil {
+		return models.Replay{}, err
+	}
+
+	return model_replay, nil
+}
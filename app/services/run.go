@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/deastl/flappybird-htmx/db"
+	"github.com/deastl/flappybird-htmx/models"
+	"github.com/deastl/flappybird-htmx/utils"
+)
+
+func runFromDb(db_run *db.Run, model_run *models.Run) error {
+	created_at, err := time.Parse(time.RFC3339, db_run.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	model_run.ID = db_run.ID
+	model_run.UserID = db_run.UserID
+	model_run.SessionID = db_run.SessionID
+	model_run.Score = int(db_run.Score)
+	model_run.Duration = time.Duration(db_run.DurationMs) * time.Millisecond
+	model_run.Frames = int(db_run.Frames)
+	model_run.Mode = db_run.Mode
+	model_run.Daily = db_run.Daily
+	model_run.Counted = db_run.Counted != 0
+	model_run.CreatedAt = created_at
+
+	return nil
+}
+
+// Stores a finished run and, if the run belongs to a user, updates their
+// last and top score
+func RunCreate(ctx context.Context, q *db.Queries, new_run *models.Run) error {
+
+	new_run.ID = utils.GenID(32)
+	new_run.CreatedAt = time.Now().UTC()
+
+	db_run_params := db.CreateRunParams{
+		ID:         new_run.ID,
+		UserID:     new_run.UserID,
+		SessionID:  new_run.SessionID,
+		Score:      int64(new_run.Score),
+		DurationMs: new_run.Duration.Milliseconds(),
+		Frames:     int64(new_run.Frames),
+		CreatedAt:  new_run.CreatedAt.Format(time.RFC3339),
+		Mode:       new_run.Mode,
+		Daily:      new_run.Daily,
+		Counted:    0,
+	}
+
+	if new_run.Counted {
+		db_run_params.Counted = 1
+	}
+
+	err := q.CreateRun(ctx, db_run_params)
+
+	if err != nil {
+		return err
+	}
+
+	if new_run.UserID == "" {
+		return nil
+	}
+
+	return UserRecordScore(ctx, q, new_run.UserID, new_run.Score)
+}
+
+// Stores a finished run and its replay in one transaction. With
+// one_daily_attempt only a player's first run of a daily challenge is
+// counted, a player's runs are recorded one after another so two of them
+// can't both be the first
+func RunRecord(ctx context.Context, connection *db.Connection, new_run *models.Run, new_replay *models.Replay, one_daily_attempt bool) error {
+	player := "user:" + new_run.UserID
+	if new_run.UserID == "" {
+		player = "session:" + new_run.SessionID
+	}
+
+	return connection.Serialized(ctx, "run:"+player, func(q *db.Queries) error {
+		if new_run.Daily != "" && one_daily_attempt {
+			attempts, err := RunCountDaily(ctx, q, new_run.Daily, new_run.UserID, new_run.SessionID)
+			if err != nil {
+				return err
+			}
+			new_run.Counted = attempts == 0
+		}
+
+		err := RunCreate(ctx, q, new_run)
+
+		if err != nil {
+			return err
+		}
+
+		new_replay.RunID = new_run.ID
+
+		return ReplayCreate(ctx, q, new_replay)
+	})
+}
+
+// Returns the best runs of a mode created at or after since, highest score first
+func RunListTop(ctx context.Context, q *db.Queries, since time.Time, mode string, limit int) ([]models.Run, error) {
+	db_runs, err := q.ListTopRunsSince(ctx, db.ListTopRunsSinceParams{
+		CreatedAt: since.UTC().Format(time.RFC3339),
+		Mode:      mode,
+		Limit:     int64(limit),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	model_runs := make([]models.Run, len(db_runs))
+
+	for i, row := range db_runs {
+		db_run := db.Run{
+			ID:         row.ID,
+			UserID:     row.UserID,
+			SessionID:  row.SessionID,
+			Score:      row.Score,
+			DurationMs: row.DurationMs,
+			Frames:     row.Frames,
+			CreatedAt:  row.CreatedAt,
+			Mode:       row.Mode,
+		}
+		err = runFromDb(&db_run, &model_runs[i])
+		if err != nil {
+			return nil, err
+		}
+		model_runs[i].UserName = row.UserName
+	}
+
+	return model_runs, nil
+}
+
+// Returns the best counted runs of a daily challenge, highest score first
+func RunListDaily(ctx context.Context, q *db.Queries, day string, limit int) ([]models.Run, error) {
+	db_runs, err := q.ListTopDailyRuns(ctx, db.ListTopDailyRunsParams{
+		Daily: day,
+		Limit: int64(limit),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	model_runs := make([]models.Run, len(db_runs))
+
+	for i, row := range db_runs {
+		db_run := db.Run{
+			ID:         row.ID,
+			UserID:     row.UserID,
+			SessionID:  row.SessionID,
+			Score:      row.Score,
+			DurationMs: row.DurationMs,
+			Frames:     row.Frames,
+			CreatedAt:  row.CreatedAt,
+			Mode:       row.Mode,
+			Daily:      day,
+			Counted:    1,
+		}
+		err = runFromDb(&db_run, &model_runs[i])
+		if err != nil {
+			return nil, err
+		}
+		model_runs[i].UserName = row.UserName
+	}
+
+	return model_runs, nil
+}
+
+// Counts the attempts a user, or a session when there is no user, made at a
+// daily challenge
+func RunCountDaily(ctx context.Context, q *db.Queries, day string, user_id string, session_id string) (int, error) {
+	var count int64
+	var err error
+
+	if user_id != "" {
+		count, err = q.CountDailyRunsByUser(ctx, db.CountDailyRunsByUserParams{
+			Daily:  day,
+			UserID: user_id,
+		})
+	} else {
+		count, err = q.CountDailyRunsBySession(ctx, db.CountDailyRunsBySessionParams{
+			Daily:     day,
+			SessionID: session_id,
+		})
+	}
+
+	return int(count), err
+}
+
+func runReplayFromDb(row *db.ListRunReplaysRow, run_replay *models.RunReplay) error {
+	db_run := db.Run{
+		ID:         row.ID,
+		UserID:     row.UserID,
+		SessionID:  row.SessionID,
+		Score:      row.Score,
+		DurationMs: row.DurationMs,
+		Frames:     row.Frames,
+		CreatedAt:  row.CreatedAt,
+		Mode:       row.Mode,
+	}
+	err := runFromDb(&db_run, &run_replay.Run)
+	if err != nil {
+		return err
+	}
+
+	db_replay := db.Replay{
+		RunID:     row.ID,
+		Seed:      row.Seed,
+		JumpTicks: row.JumpTicks,
+		CreatedAt: row.CreatedAt,
+		Gameplay:  row.Gameplay,
+	}
+
+	return replayFromDb(&db_replay, &run_replay.Replay)
+}
+
+// Returns every stored run that has a replay, oldest first
+func RunListWithReplays(ctx context.Context, q *db.Queries) ([]models.RunReplay, error) {
+	rows, err := q.ListRunReplays(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	run_replays := make([]models.RunReplay, len(rows))
+
+	for i := range rows {
+		err = runReplayFromDb(&rows[i], &run_replays[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return run_replays, nil
+}
+
+// Returns the highest scoring run of a mode by a user, or by a session when
+// there is no user, together with its replay
+func RunGetBest(ctx context.Context, q *db.Queries, user_id string, session_id string, mode string) (models.RunReplay, error) {
+	var row db.ListRunReplaysRow
+
+	if user_id != "" {
+		user_row, err := q.GetBestRunReplayByUser(ctx, db.GetBestRunReplayByUserParams{
+			UserID: user_id,
+			Mode:   mode,
+		})
+		if err != nil {
+			return models.RunReplay{}, err
+		}
+		row = db.ListRunReplaysRow(user_row)
+	} else {
+		session_row, err := q.GetBestRunReplayBySession(ctx, db.GetBestRunReplayBySessionParams{
+			SessionID: session_id,
+			Mode:      mode,
+		})
+		if err != nil {
+			return models.RunReplay{}, err
+		}
+		row = db.ListRunReplaysRow(session_row)
+	}
+
+	run_replay := models.RunReplay{}
+	err := runReplayFromDb(&row, &run_replay)
+
+	if err != nil {
+		return models.RunReplay{}, err
+	}
+
+	return run_replay, nil
+}
+
+// Returns a run together with its replay
+func RunGetWithReplay(ctx context.Context, q *db.Queries, run_id string) (models.RunReplay, error) {
+	db_row, err := q.GetRunReplay(ctx, run_id)
+	if err != nil {
+		return models.RunReplay{}, err
+	}
+
+	row := db.ListRunReplaysRow(db_row)
+	run_replay := models.RunReplay{}
+	err = runReplayFromDb(&row, &run_replay)
+
+	if err != nil {
+		return models.RunReplay{}, err
+	}
+
+	return run_replay, nil
+}
+
+// Removes a run and its replay
+func RunDelete(ctx context.Context, q *db.Queries, run_id string) error {
+	err := q.DeleteReplay(ctx, run_id)
+	if err != nil {
+		return err
+	}
+
+	return q.DeleteRun(ctx, run_id)
+}
+
+func RunCountByUser(ctx context.Context, q *db.Queries, user_id string) (int, error) {
+	count, err := q.CountRunsByUser(ctx, user_id)
+
+	return int(count), err
+}
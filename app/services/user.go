@@ -2,8 +2,12 @@ package services
 
 import (
 	"context"
-	"log"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/deastl/flappybird-htmx/db"
 	"github.com/deastl/flappybird-htmx/models"
@@ -20,6 +24,7 @@ func userFromDb(db_user *db.User, model_user *models.User) error {
 		return err
 	}
 
+	model_user.ID = db_user.ID
 	model_user.CreatedAt = created_at
 	model_user.UpdatedAt = updated_at
 
@@ -49,10 +54,47 @@ func UserCreate(ctx context.Context, q *db.Queries, new_user *models.User) error
 	return q.CreateUser(ctx, db_user_params)
 }
 
+// Creates the user of a token under the token's ID when this database
+// doesn't have it, tokens outlive a node's database and are valid on every
+// node sharing the secret
+func UserRestore(ctx context.Context, q *db.Queries, user_id string) error {
+	_, err := q.GetUserByID(ctx, user_id)
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+
+	return q.CreateUser(ctx, db.CreateUserParams{
+		ID:        user_id,
+		Name:      "",
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+}
+
+func UserGetByID(ctx context.Context, q *db.Queries, id string) (models.User, error) {
+	db_user, err := q.GetUserByID(ctx, id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	model_user := models.User{}
+	err = userFromDb(&db_user, &model_user)
+
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return model_user, nil
+}
+
+// Looks a user up by display name, ignoring case like the unique index does
 func UserGetByName(ctx context.Context, q *db.Queries, name string) (models.User, error) {
 	db_user, err := q.GetUserByName(ctx, name)
 	if err != nil {
-		log.Fatalf("error in UserGetByName : %v", err)
+		return models.User{}, err
 	}
 
 	model_user := models.User{}
@@ -64,3 +106,147 @@ func UserGetByName(ctx context.Context, q *db.Queries, name string) (models.User
 
 	return model_user, nil
 }
+
+func UserRecordScore(ctx context.Context, q *db.Queries, user_id string, score int) error {
+	err := UserRestore(ctx, q, user_id)
+	if err != nil {
+		return err
+	}
+
+	db_user, err := q.GetUserByID(ctx, user_id)
+	if err != nil {
+		return err
+	}
+
+	top_score := db_user.TopScore
+	if int64(score) > top_score {
+		top_score = int64(score)
+	}
+
+	return q.UpdateUserScores(ctx, db.UpdateUserScoresParams{
+		LastScore: int64(score),
+		TopScore:  top_score,
+		UpdatedAt: time.Now().Format(time.RFC3339),
+		ID:        user_id,
+	})
+}
+
+const (
+	userNameMinLength = 3
+	userNameMaxLength = 16
+)
+
+var (
+	ErrNameInvalid = errors.New("names are 3 to 16 letters, digits, - or _")
+	ErrNameBlocked = errors.New("that name is not allowed")
+	ErrNameTaken   = errors.New("that name is already taken")
+)
+
+// Lowercase words a name may not have as one of its words
+var blockedNameWords = []string{
+	"admin", "administrator", "moderator", "fag", "nazi", "rape", "rapist",
+}
+
+// Lowercase stems no harmless word starts with, blocked at the start of any
+// word of a name even when it runs on into the next words, so "sHit" or
+// "f_uck" can't split a stem
+var blockedNameStems = []string{
+	"fuck", "shit", "cunt", "bitch", "nigg", "fagg", "whore", "slut",
+}
+
+// Splits a name into lowercase words at -, _, digits and where a lowercase
+// letter is followed by an uppercase one, "BigGrape_99" has big and grape
+func nameWords(name string) []string {
+	words := []string{}
+	word := strings.Builder{}
+	previous_lower := false
+
+	for _, c := range name {
+		is_lower := c >= 'a' && c <= 'z'
+		is_upper := c >= 'A' && c <= 'Z'
+
+		if (!is_lower && !is_upper) || (is_upper && previous_lower) {
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		}
+
+		if is_lower || is_upper {
+			word.WriteRune(unicode.ToLower(c))
+		}
+
+		previous_lower = is_lower
+	}
+
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+
+	return words
+}
+
+func UserValidateName(name string) error {
+	if len(name) < userNameMinLength || len(name) > userNameMaxLength {
+		return ErrNameInvalid
+	}
+
+	for _, c := range name {
+		is_letter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		is_digit := c >= '0' && c <= '9'
+		if !is_letter && !is_digit && c != '-' && c != '_' {
+			return ErrNameInvalid
+		}
+	}
+
+	// Matched by word so names like grape or scrape stay allowed
+	words := nameWords(name)
+	for i, word := range words {
+		if slices.Contains(blockedNameWords, word) {
+			return ErrNameBlocked
+		}
+		rest := strings.Join(words[i:], "")
+		for _, stem := range blockedNameStems {
+			if strings.HasPrefix(rest, stem) {
+				return ErrNameBlocked
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validates a display name and gives it to the user if nobody else has it,
+// whatever its case
+func UserClaimName(ctx context.Context, q *db.Queries, user_id string, name string) error {
+	err := UserValidateName(name)
+	if err != nil {
+		return err
+	}
+
+	owner, err := q.GetUserByName(ctx, name)
+	if err == nil && owner.ID != user_id {
+		return ErrNameTaken
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	err = UserRestore(ctx, q, user_id)
+	if err != nil {
+		return err
+	}
+
+	err = q.UpdateUserName(ctx, db.UpdateUserNameParams{
+		Name:      name,
+		UpdatedAt: time.Now().Format(time.RFC3339),
+		ID:        user_id,
+	})
+
+	// Another player claimed it between the check and the update
+	if db.IsUniqueViolation(err) {
+		return ErrNameTaken
+	}
+
+	return err
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/deastl/flappybird-htmx/db"
+	"github.com/deastl/flappybird-htmx/models"
+)
+
+func TestNameWords(t *testing.T) {
+	cases := []struct {
+		name string
+		want []string
+	}{
+		{"grape", []string{"grape"}},
+		{"BigGrape_99", []string{"big", "grape"}},
+		{"xX-slayer-Xx", []string{"x", "x", "slayer", "xx"}},
+		{"ABC12def", []string{"abc", "def"}},
+		{"__", []string{}},
+	}
+
+	for _, c := range cases {
+		if got := nameWords(c.name); !slices.Equal(got, c.want) {
+			t.Errorf("nameWords(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestUserValidateName(t *testing.T) {
+	cases := []struct {
+		name string
+		want error
+	}{
+		{"grape", nil},
+		{"scrape", nil},
+		{"Scunthorpe", nil},
+		{"badminton", nil},
+		{"nazim", nil},
+		{"ab", ErrNameInvalid},
+		{"bad name", ErrNameInvalid},
+		{"Admin", ErrNameBlocked},
+		{"the_admin", ErrNameBlocked},
+		{"SuperAdmin", ErrNameBlocked},
+		{"rape99", ErrNameBlocked},
+		{"Fucker", ErrNameBlocked},
+		{"x-shithead", ErrNameBlocked},
+		{"sHit", ErrNameBlocked},
+		{"fUck", ErrNameBlocked},
+		{"big_f-u_ck", ErrNameBlocked},
+		{"glass_lut", nil},
+	}
+
+	for _, c := range cases {
+		if err := UserValidateName(c.name); !errors.Is(err, c.want) {
+			t.Errorf("UserValidateName(%q) = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+// Tokens are valid on every node, but the user may only be in another
+// node's database
+func TestRunRecordRestoresMissingUser(t *testing.T) {
+	ctx := context.Background()
+	connection, err := db.NewConnection(db.Config{Dialect: db.SQLite, DSN: ":memory:"})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer connection.DB.Close()
+
+	run := models.Run{UserID: "from-another-node", SessionID: "session", Score: 7, Mode: "normal", Counted: true}
+	replay := models.Replay{Seed: 1, JumpTicks: []int{3}}
+
+	err = RunRecord(ctx, connection, &run, &replay, false)
+	if err != nil {
+		t.Fatalf("RunRecord: %v", err)
+	}
+
+	user, err := UserGetByID(ctx, connection.Queries, "from-another-node")
+	if err != nil {
+		t.Fatalf("user not restored: %v", err)
+	}
+	if user.TopScore != 7 {
+		t.Errorf("restored user has top score %d, want 7", user.TopScore)
+	}
+
+	_, err = RunGetWithReplay(ctx, connection.Queries, run.ID)
+	if err != nil {
+		t.Errorf("run not recorded: %v", err)
+	}
+
+	err = UserClaimName(ctx, connection.Queries, "also-elsewhere", "grape")
+	if err != nil {
+		t.Fatalf("UserClaimName: %v", err)
+	}
+	user, err = UserGetByID(ctx, connection.Queries, "also-elsewhere")
+	if err != nil || user.Name != "grape" {
+		t.Errorf("named user = %+v, %v", user, err)
+	}
+}
@@ -5,13 +5,23 @@ import (
 	"strings"
 )
 
+const idAlphabet = "abcdefghijklmnopqrstuvwxyz-_"
+
 func GenID(length int) string {
-	const alphabet = "abcdefghijklmnopqrstuvwxyz-_"
+	id := make([]byte, length)
+
+	for i := range id {
+		id[i] = idAlphabet[rand.Intn(len(idAlphabet))]
+	}
+	return string(id)
+}
 
+// Same as GenID but draws from rng, so IDs can be reproduced from a seed
+func GenIDFrom(rng *rand.Rand, length int) string {
 	id := make([]byte, length)
 
 	for i := range id {
-		id[i] = alphabet[rand.Intn(len(alphabet))]
+		id[i] = idAlphabet[rng.Intn(len(idAlphabet))]
 	}
 	return string(id)
 }
@@ -54,6 +54,8 @@ resource "aws_instance" "instance" {
     private_key = nonsensitive(lookup(acme_certificate.certificate, "private_key_pem")),
     cert = lookup(acme_certificate.certificate, "certificate_pem"),
     domain = "${var.name}.${data.aws_route53_zone.base_domain.name}"
+    database_url = var.database_url
+    jwt_secret = var.jwt_secret
   })
 
   depends_on = [acme_certificate.certificate]
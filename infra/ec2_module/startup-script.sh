@@ -37,5 +37,5 @@ EOF
 
 systemctl restart nginx
 
-docker run -d --network host jhartway99/htmx-flappybird
+docker run -d --network host -e database_url='${database_url}' -e jwt_secret='${jwt_secret}' jhartway99/htmx-flappybird
 git clone https://github.com/DeaSTL/flappybird-htmx
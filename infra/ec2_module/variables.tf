@@ -9,3 +9,14 @@ variable "ami" {
 variable "route_zone_id" {
   type = string
 }
+
+variable "database_url" {
+  type = string
+  default = ""
+  sensitive = true
+}
+
+variable "jwt_secret" {
+  type = string
+  sensitive = true
+}
@@ -8,6 +8,21 @@ terraform {
   }
 }
 
+# Shared postgres url so every region records to the same scoreboard, each
+# node keeps its own sqlite file when empty
+variable "database_url" {
+  type = string
+  default = ""
+  sensitive = true
+}
+
+# Signs the players' tokens, every region has to share it so players keep
+# their identity wherever they are routed
+variable "jwt_secret" {
+  type = string
+  sensitive = true
+}
+
 provider "aws" {
   alias = "east"
   region = "us-east-1"
@@ -32,6 +47,8 @@ module "ec2_east" {
     aws = aws.east
   }
   route_zone_id = "Z00325221D3WV6IM3G34A"
+  database_url = var.database_url
+  jwt_secret = var.jwt_secret
 }
 module "ec2_mid" {
   source = "./ec2_module"
@@ -41,6 +58,8 @@ module "ec2_mid" {
     aws = aws.mid
   }
   route_zone_id = "Z00325221D3WV6IM3G34A"
+  database_url = var.database_url
+  jwt_secret = var.jwt_secret
 }
 module "ec2_west" {
   source = "./ec2_module"
@@ -50,6 +69,8 @@ module "ec2_west" {
     aws = aws.west
   }
   route_zone_id = "Z00325221D3WV6IM3G34A"
+  database_url = var.database_url
+  jwt_secret = var.jwt_secret
 }
 
 